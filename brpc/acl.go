@@ -0,0 +1,80 @@
+package brpc
+
+import (
+	"errors"
+	"path"
+	"sync"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ACL 控制客户端可以调用哪些方法，规则是"Service.Method"形式的path.Match模式，
+// 例如"monitor.*"，"*"表示所有方法。客户端的权限是它自己的规则加上所在组的规则，
+// 没有匹配任何规则的调用会被拒绝。
+type ACL struct {
+	lock    sync.RWMutex
+	clients map[bcrypt.NoisePublicKey][]string
+	groups  map[string][]string
+	members map[bcrypt.NoisePublicKey][]string
+}
+
+func NewACL() *ACL {
+	return &ACL{
+		clients: make(map[bcrypt.NoisePublicKey][]string),
+		groups:  make(map[string][]string),
+		members: make(map[bcrypt.NoisePublicKey][]string),
+	}
+}
+
+func (a *ACL) AllowClient(pk bcrypt.NoisePublicKey, patterns ...string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.clients[pk] = append(a.clients[pk], patterns...)
+}
+
+func (a *ACL) AllowGroup(group string, patterns ...string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.groups[group] = append(a.groups[group], patterns...)
+}
+
+func (a *ACL) AddToGroup(group string, pks ...bcrypt.NoisePublicKey) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, pk := range pks {
+		a.members[pk] = append(a.members[pk], group)
+	}
+}
+
+func (a *ACL) RemoveClient(pk bcrypt.NoisePublicKey) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.clients, pk)
+	delete(a.members, pk)
+}
+
+func (a *ACL) Allowed(pk bcrypt.NoisePublicKey, serviceMethod string) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if match(a.clients[pk], serviceMethod) {
+		return true
+	}
+	for _, group := range a.members[pk] {
+		if match(a.groups[group], serviceMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+func match(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
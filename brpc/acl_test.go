@@ -0,0 +1,80 @@
+package brpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestACL(t *testing.T) {
+	ts := newTestServer(t)
+	admin, _ := bcrypt.NewPrivateKey()
+	ts.AddClientPublicKey(admin.PublicKey())
+	ts.HandleStream("log.Tail", func(st *brpc.ServerStream) error { return nil })
+
+	acl := brpc.NewACL()
+	acl.AllowClient(ts.clientKey.PublicKey(), "service.Q*")
+	acl.AllowGroup("admin", "*")
+	acl.AddToGroup("admin", admin.PublicKey())
+	ts.SetACL(acl)
+
+	c := ts.client(t, rw.WithMux())
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var b Blob
+	if err := c.Call("service.Echo", Blob{}, &b); err != brpc.ErrPermissionDenied {
+		t.Fatal(err)
+	}
+	st, err := c.NewStream(context.Background(), "log.Tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.Recv(nil); err != brpc.ErrPermissionDenied {
+		t.Fatal(err)
+	}
+
+	if err := ts.httpClient(t).Call("service.Echo", Blob{}, &b); err != brpc.ErrPermissionDenied {
+		t.Fatal(err)
+	}
+
+	if err := ts.clientWithKey(t, admin).Call("service.Echo", Blob{}, &b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestACLShortCircuit(t *testing.T) {
+	// 不调用next直接给出结果的拦截器，例如缓存，不能让被拒绝的客户端拿到结果
+	ts := newTestServer(t, brpc.WithInterceptors(func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		if call.Stream != nil {
+			return call.Stream.Send(Resp{Age: 1})
+		}
+		call.Response = &Resp{Age: 1}
+		return nil
+	}))
+	ts.HandleStream("log.Tail", func(st *brpc.ServerStream) error { return nil })
+	ts.SetACL(brpc.NewACL())
+
+	c := ts.client(t, rw.WithMux())
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != brpc.ErrPermissionDenied || out.Age != 0 {
+		t.Fatal(err, out)
+	}
+	if err := ts.httpClient(t).Call("service.Query", Req{Name: "admin"}, &out); err != brpc.ErrPermissionDenied || out.Age != 0 {
+		t.Fatal(err, out)
+	}
+
+	st, err := c.NewStream(context.Background(), "log.Tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.Recv(&out); err != brpc.ErrPermissionDenied || out.Age != 0 {
+		t.Fatal(err, out)
+	}
+}
@@ -0,0 +1,55 @@
+package brpc
+
+import (
+	"context"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+// ClientInfo 是通过验证的客户端，Metadata是添加客户端公钥时附带的信息，不要修改
+type ClientInfo struct {
+	PublicKey bcrypt.NoisePublicKey
+	Metadata  map[string]string
+}
+
+type clientInfoKey struct{}
+
+func newClientContext(ctx context.Context, client *ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, client)
+}
+
+func ClientFromContext(ctx context.Context) (*ClientInfo, bool) {
+	client, ok := ctx.Value(clientInfoKey{}).(*ClientInfo)
+	return client, ok
+}
+
+// Caller 嵌入到Register注册的方法的请求参数里，服务端解码请求后会把调用信息注入进去，
+// Caller没有导出的字段，不会影响请求的编码。
+//
+//	type QueryRequest struct {
+//		brpc.Caller
+//		Name string
+//	}
+type Caller struct {
+	ctx context.Context
+}
+
+type callerSetter interface {
+	setCallerContext(ctx context.Context)
+}
+
+func (c *Caller) setCallerContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+func (c *Caller) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *Caller) Client() *ClientInfo {
+	client, _ := ClientFromContext(c.Context())
+	return client
+}
@@ -0,0 +1,64 @@
+package brpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+type WhoReq struct {
+	brpc.Caller
+	X int
+}
+
+type Who struct{}
+
+func (Who) Am(req WhoReq, resp *map[string]string) error {
+	client := req.Client()
+	if client == nil {
+		return errors.New("no client")
+	}
+	*resp = map[string]string{"key": client.PublicKey.String(), "tenant": client.Metadata["tenant"]}
+	return nil
+}
+
+func TestCaller(t *testing.T) {
+	ts := newTestServer(t)
+	ts.AddClient(ts.clientKey.PublicKey(), map[string]string{"tenant": "acme"})
+	ts.RegisterName("who", Who{})
+
+	var out map[string]string
+	if err := ts.client(t).Call("who.Am", WhoReq{X: 1}, &out); err != nil {
+		t.Fatal(err)
+	}
+	pk := ts.clientKey.PublicKey()
+	if out["tenant"] != "acme" || out["key"] != pk.String() {
+		t.Fatal(out)
+	}
+}
+
+func TestClientFromContext(t *testing.T) {
+	ts := newTestServer(t)
+	brpc.HandleFunc(ts.Server, "who.Key", func(ctx context.Context, req *int) (*string, error) {
+		client, ok := brpc.ClientFromContext(ctx)
+		if !ok {
+			return nil, errors.New("no client")
+		}
+		key := client.PublicKey.String()
+		return &key, nil
+	})
+
+	var key string
+	if err := ts.httpClient(t).Call("who.Key", 1, &key); err != nil {
+		t.Fatal(err)
+	}
+	if pk := ts.clientKey.PublicKey(); key != pk.String() {
+		t.Fatal(key)
+	}
+
+	if _, ok := brpc.ClientFromContext(context.Background()); ok {
+		t.Fatal("client outside a call")
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync/atomic"
 	"time"
 
 	"github.com/abxuz/b-tools/v2/bcrypt"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+var ErrRequestRejected = errors.New("request rejected by server")
+
 type LogicError string
 
 func (e LogicError) Error() string { return string(e) }
@@ -25,6 +28,13 @@ type Client struct {
 	serverPubKey     *bcrypt.NoisePublicKey
 	clientPubKeyHash []byte
 	ssHash           []byte
+	codec            Codec
+	compression      *compression
+	interceptors     []ClientInterceptor
+	metrics          Metrics
+
+	// 服务端在响应头部中告诉客户端它可以解压的压缩方式
+	serverAcceptCompression atomic.Value
 }
 
 func (c *Client) SetClientPrivateKey(pk bcrypt.NoisePrivateKey) {
@@ -39,26 +49,93 @@ func (c *Client) SetServerPublicKey(pk bcrypt.NoisePublicKey) {
 	c.tryFixSsHash()
 }
 
+// SetCodec 设置请求和响应body的编码方式，默认是msgpack，服务端需要支持同样的编码方式
+func (c *Client) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
+func (c *Client) Codec() Codec {
+	if c.codec == nil {
+		return MsgpackCodec
+	}
+	return c.codec
+}
+
+// SetCompression 开启压缩，服务端表示可以解压后，编码后达到threshold字节的请求body会被压缩，
+// 服务端也可以压缩发过来的响应，maxSize是解压后的最大长度，不大于0时使用DefaultMaxDecompressedSize
+func (c *Client) SetCompression(threshold int, maxSize int) {
+	c.compression = newCompression(threshold, maxSize)
+}
+
 func (c *Client) WriteRequestMessage(
 	dst []byte, serviceName string, req any,
 	ePubKeyOut *bcrypt.NoisePublicKey, tOut *int64,
 ) (dataOut []byte, err error) {
-	ePrivKey, err := bcrypt.NewPrivateKey()
+	return c.WriteRequestMessageContext(context.Background(), dst, serviceName, req, ePubKeyOut, tOut)
+}
+
+// WriteRequestMessageContext 和WriteRequestMessage一样，同时把ctx的超时时间和metadata带给服务端
+func (c *Client) WriteRequestMessageContext(
+	ctx context.Context, dst []byte, serviceName string, req any,
+	ePubKeyOut *bcrypt.NoisePublicKey, tOut *int64,
+) (dataOut []byte, err error) {
+	h, err := newRequestHeader(ctx, c.Codec())
 	if err != nil {
 		return nil, err
 	}
+	h.AcceptCompression = c.compression.accept()
 
+	buffer := c.newMessageBuffer(dst)
+	buffer.WriteByte(byte(len(serviceName)))
+	buffer.WriteString(serviceName)
+	return c.sealMessage(buffer, req, h, ePubKeyOut, tOut)
+}
+
+// WriteStreamMessage 生成流上的后续消息，和请求消息相比不带服务名，带着流的标识和序号seq，
+// 流上发出的第一条消息序号为0，之后每发出一条加1
+func (c *Client) WriteStreamMessage(
+	dst []byte, key *StreamKey, seq uint64, msg any,
+	ePubKeyOut *bcrypt.NoisePublicKey, tOut *int64,
+) (dataOut []byte, err error) {
+	buffer := c.newMessageBuffer(dst)
+	buffer.Write(key.appendPrefix(nil, seq))
+	return c.sealMessage(buffer, msg, nil, ePubKeyOut, tOut)
+}
+
+func (c *Client) newMessageBuffer(dst []byte) *bytes.Buffer {
 	buffer := bytes.NewBuffer(dst)
 	buffer.Write(c.clientPubKeyHash)
 	buffer.Write(c.ssHash)
-	buffer.WriteByte(byte(len(serviceName)))
-	buffer.WriteString(serviceName)
-	err = msgpack.NewEncoder(buffer).Encode(req)
+	return buffer
+}
+
+func (c *Client) sealMessage(
+	buffer *bytes.Buffer, body any, h *header,
+	ePubKeyOut *bcrypt.NoisePublicKey, tOut *int64,
+) (dataOut []byte, err error) {
+	ePrivKey, err := bcrypt.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.Codec().Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 
-	data := buffer.Bytes()
+	if h != nil {
+		accept, _ := c.serverAcceptCompression.Load().([]byte)
+		data, h.Compression = c.compression.compress(data, accept)
+	}
+	buffer.Write(data)
+
+	if h != nil {
+		if err := writeHeader(buffer, h); err != nil {
+			return nil, err
+		}
+	}
+
+	data = buffer.Bytes()
 	t := time.Now().Unix()
 	data, err = encrypt(&ePrivKey, c.serverPubKey, t, data)
 	if err != nil {
@@ -70,25 +147,94 @@ func (c *Client) WriteRequestMessage(
 	return data, nil
 }
 
+// ReadResponseMessage 解析响应，resp为nil时只检查响应状态
 func (c *Client) ReadResponseMessage(resp any, data []byte, ePubKey *bcrypt.NoisePublicKey, t int64) error {
-	data, err := decrypt(c.clientPrivKey, ePubKey, t, data)
+	return c.ReadResponseMessageContext(context.Background(), resp, data, ePubKey, t)
+}
+
+// ReadResponseMessageContext 和ReadResponseMessage一样，同时把服务端返回的metadata
+// 写入ReceiveResponseMetadata设置的Metadata
+func (c *Client) ReadResponseMessageContext(
+	ctx context.Context, resp any, data []byte,
+	ePubKey *bcrypt.NoisePublicKey, t int64,
+) error {
+	data, err := c.openResponse(data, ePubKey, t)
+	if err != nil {
+		return err
+	}
+
+	body, h := splitHeader(data[1:])
+	if h != nil {
+		c.serverAcceptCompression.Store(h.AcceptCompression)
+	}
+	receiveMetadata(ctx, h)
+	return c.readResponse(resp, data[0], body, h)
+}
+
+// ReadStreamMessage 解析流上的消息，和响应相比不带扩展头部，msg为nil时只检查状态。
+// 消息不属于这个流或者序号不是*seq时返回ErrStreamSequence，否则*seq加1
+func (c *Client) ReadStreamMessage(
+	msg any, key *StreamKey, seq *uint64,
+	data []byte, ePubKey *bcrypt.NoisePublicKey, t int64,
+) error {
+	data, err := c.openResponse(data, ePubKey, t)
 	if err != nil {
 		return err
 	}
 
+	data, err = key.checkPrefix(data, *seq)
+	if err != nil {
+		return err
+	}
+	*seq++
+
 	if len(data) < 1 {
 		return io.ErrUnexpectedEOF
 	}
+	return c.readResponse(msg, data[0], data[1:], nil)
+}
 
-	if data[0] == 0xff {
-		return LogicError(data[1:])
+func (c *Client) openResponse(data []byte, ePubKey *bcrypt.NoisePublicKey, t int64) ([]byte, error) {
+	data, err := decrypt(c.clientPrivKey, ePubKey, t, data)
+	if err != nil {
+		return nil, ErrDecryptFailed
 	}
 
-	if data[0] != 0xfe {
+	if len(data) < 1 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+func (c *Client) readResponse(resp any, status byte, body []byte, h *header) error {
+	switch status {
+	case 0xff:
+		return LogicError(body)
+	case 0xfd:
+		return ErrPermissionDenied
+	case 0xfc:
+		e := new(Error)
+		if err := msgpack.Unmarshal(body, e); err != nil {
+			return err
+		}
+		return e
+	case 0xfe:
+	default:
 		return errors.New("invalid response data")
 	}
 
-	return msgpack.Unmarshal(data[1:], resp)
+	if resp == nil {
+		return nil
+	}
+
+	if h != nil {
+		var err error
+		body, err = c.compression.decompress(body, h.Compression)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Codec().Unmarshal(body, resp)
 }
 
 func (c *Client) tryFixSsHash() {
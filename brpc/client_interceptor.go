@@ -0,0 +1,36 @@
+package brpc
+
+import (
+	"context"
+	"time"
+)
+
+type Invoker = func(ctx context.Context, serviceName string, req any, resp any) error
+
+// ClientInterceptor 包装客户端的每一次调用，可以用来重试、统计、打日志等，
+// 调用invoker才会真正发出请求，可以调用多次
+type ClientInterceptor = func(ctx context.Context, serviceName string, req any, resp any, invoker Invoker) error
+
+// AddInterceptors 按顺序添加拦截器，第一个在最外层
+func (c *Client) AddInterceptors(interceptors ...ClientInterceptor) {
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// Invoke 依次执行拦截器，最后由invoker发出请求，各个传输方式的CallContext都通过它调用
+func (c *Client) Invoke(ctx context.Context, serviceName string, req any, resp any, invoker Invoker) error {
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceName string, req any, resp any) error {
+			return interceptor(ctx, serviceName, req, resp, next)
+		}
+	}
+
+	if c.metrics == nil {
+		return invoker(ctx, serviceName, req, resp)
+	}
+
+	start := time.Now()
+	err := invoker(ctx, serviceName, req, resp)
+	c.metrics.ObserveCall("client", serviceName, Outcome(err), time.Since(start))
+	return err
+}
@@ -0,0 +1,59 @@
+package brpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	bhttp "github.com/abxuz/b-tools/v2/brpc/http"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestClientInterceptors(t *testing.T) {
+	ts := newTestServer(t)
+
+	var order []string
+	tag := func(name string) brpc.ClientInterceptor {
+		return func(ctx context.Context, serviceName string, req, resp any, invoker brpc.Invoker) error {
+			order = append(order, name)
+			return invoker(ctx, serviceName, req, resp)
+		}
+	}
+	// 第一次失败时改一下请求再试一次
+	retry := func(ctx context.Context, serviceName string, req, resp any, invoker brpc.Invoker) error {
+		err := invoker(ctx, serviceName, req, resp)
+		if err != nil {
+			return invoker(ctx, serviceName, Req{Name: "admin"}, resp)
+		}
+		return err
+	}
+
+	clients := map[string]brpc.RpcClient{
+		"rw":   ts.client(t, rw.WithInterceptors(tag("a"), tag("b"), retry)),
+		"http": ts.httpClient(t, bhttp.WithInterceptors(tag("a"), tag("b"), retry)),
+	}
+	for name, c := range clients {
+		order = nil
+		var out Resp
+		if err := c.Call("service.Query", Req{Name: "x"}, &out); err != nil || out.Age != 100 {
+			t.Fatal(name, err, out)
+		}
+		if len(order) != 2 || order[0] != "a" || order[1] != "b" {
+			t.Fatal(name, order)
+		}
+	}
+}
+
+func TestClientInterceptorShortCircuit(t *testing.T) {
+	ts := newTestServer(t)
+	errBlocked := errors.New("blocked")
+	c := ts.client(t, rw.WithInterceptors(func(ctx context.Context, serviceName string, req, resp any, invoker brpc.Invoker) error {
+		return errBlocked
+	}))
+
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != errBlocked {
+		t.Fatal(err)
+	}
+}
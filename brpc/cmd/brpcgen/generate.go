@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type generator struct {
+	dir         string
+	typeName    string
+	serviceName string
+
+	fset    *token.FileSet
+	pkgName string
+	methods []*method
+	imports map[string]string
+}
+
+type method struct {
+	Name     string
+	ReqType  string
+	RespType string
+}
+
+func (g *generator) generate() ([]byte, error) {
+	g.fset = token.NewFileSet()
+	g.imports = make(map[string]string)
+
+	// 和go build一样按构建约束选择文件，忽略测试文件和其他包的文件
+	pkg, err := build.ImportDir(g.dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	g.pkgName = pkg.Name
+
+	for _, name := range append(pkg.GoFiles, pkg.CgoFiles...) {
+		file, err := parser.ParseFile(g.fset, filepath.Join(pkg.Dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if isGenerated(file) {
+			continue
+		}
+
+		if err := g.collectMethods(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(g.methods) == 0 {
+		return nil, fmt.Errorf("type %v has no exported methods of suitable type", g.typeName)
+	}
+	sort.Slice(g.methods, func(i, j int) bool { return g.methods[i].Name < g.methods[j].Name })
+
+	buffer := new(bytes.Buffer)
+	err = clientTemplate.Execute(buffer, map[string]any{
+		"Package":     g.pkgName,
+		"Imports":     g.importLines(),
+		"Type":        g.typeName,
+		"Client":      g.typeName + "Client",
+		"Impl":        lowerFirst(g.typeName) + "Client",
+		"ServiceName": g.serviceName,
+		"Methods":     g.methods,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buffer.Bytes())
+}
+
+var generatedRegexp = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if generatedRegexp.MatchString(comment.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectMethods 和Server.Register一样只要
+//
+//	func (t *T) MethodName(req T1, resp *T2) error
+//
+// 形式的导出方法，T1和T2需要是导出的或者内置的类型
+func (g *generator) collectMethods(file *ast.File) error {
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || !fn.Name.IsExported() {
+			continue
+		}
+		if receiverName(fn.Recv.List[0].Type) != g.typeName {
+			continue
+		}
+
+		params := flattenFields(fn.Type.Params)
+		results := flattenFields(fn.Type.Results)
+		if len(params) != 2 || len(results) != 1 {
+			continue
+		}
+		if ident, ok := results[0].(*ast.Ident); !ok || ident.Name != "error" {
+			continue
+		}
+
+		reqType, respType := params[0], params[1]
+		star, ok := respType.(*ast.StarExpr)
+		if !ok {
+			continue
+		}
+		if !isExportedOrBuiltinType(reqType) || !isExportedOrBuiltinType(respType) {
+			continue
+		}
+
+		for _, t := range []ast.Expr{reqType, respType} {
+			if err := g.addImports(file, t); err != nil {
+				return err
+			}
+		}
+
+		g.methods = append(g.methods, &method{
+			Name:     fn.Name.Name,
+			ReqType:  g.exprString(reqType),
+			RespType: g.exprString(star.X),
+		})
+	}
+	return nil
+}
+
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// flattenFields 把"a, b int"展开成每个参数一项
+func flattenFields(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+
+	var exprs []ast.Expr
+	for _, field := range fields.List {
+		n := max(len(field.Names), 1)
+		for i := 0; i < n; i++ {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return exprs
+}
+
+// isExportedOrBuiltinType 对应reflect中的判断，没有名字的复合类型也算内置类型
+func isExportedOrBuiltinType(expr ast.Expr) bool {
+	for {
+		star, ok := expr.(*ast.StarExpr)
+		if !ok {
+			break
+		}
+		expr = star.X
+	}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.IsExported() || types.Universe.Lookup(t.Name) != nil
+	case *ast.SelectorExpr:
+		return t.Sel.IsExported()
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.StructType, *ast.InterfaceType:
+		return true
+	}
+	return false
+}
+
+// addImports 记录类型中用到的包
+func (g *generator) addImports(file *ast.File, expr ast.Expr) error {
+	var err error
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+
+		importPath, found := findImport(file, pkg.Name)
+		if !found {
+			err = fmt.Errorf("can't find import for %v", pkg.Name)
+			return false
+		}
+		g.imports[importPath] = pkg.Name
+		return false
+	})
+	return err
+}
+
+func findImport(file *ast.File, name string) (string, bool) {
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil {
+			if spec.Name.Name == name {
+				return importPath, true
+			}
+			continue
+		}
+		if guessPackageName(importPath) == name {
+			return importPath, true
+		}
+	}
+	return "", false
+}
+
+// guessPackageName 从导入路径猜包名，猜不准时生成的import会带上名字
+func guessPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if len(base) > 1 && base[0] == 'v' && strings.Trim(base[1:], "0123456789") == "" {
+		base = path.Base(path.Dir(importPath))
+	}
+	return base
+}
+
+func (g *generator) importLines() []string {
+	lines := []string{strconv.Quote("context"), "", strconv.Quote("github.com/abxuz/b-tools/v2/brpc")}
+	paths := make([]string, 0, len(g.imports))
+	for importPath := range g.imports {
+		if importPath != "context" && importPath != "github.com/abxuz/b-tools/v2/brpc" {
+			paths = append(paths, importPath)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, importPath := range paths {
+		name := g.imports[importPath]
+		if name == guessPackageName(importPath) {
+			lines = append(lines, strconv.Quote(importPath))
+		} else {
+			lines = append(lines, name+" "+strconv.Quote(importPath))
+		}
+	}
+	return lines
+}
+
+func (g *generator) exprString(expr ast.Expr) string {
+	buffer := new(bytes.Buffer)
+	printer.Fprint(buffer, g.fset, expr)
+	return buffer.String()
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by brpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+// {{.Client}} 是{{.Type}}的客户端
+type {{.Client}} interface {
+{{- range .Methods}}
+	{{.Name}}(ctx context.Context, req {{.ReqType}}) (*{{.RespType}}, error)
+{{- end}}
+}
+
+type {{.Impl}} struct {
+	client brpc.RpcClient
+}
+
+func New{{.Client}}(client brpc.RpcClient) {{.Client}} {
+	return &{{.Impl}}{client: client}
+}
+{{range .Methods}}
+func (c *{{$.Impl}}) {{.Name}}(ctx context.Context, req {{.ReqType}}) (*{{.RespType}}, error) {
+	resp := new({{.RespType}})
+	if err := c.client.CallContext(ctx, "{{$.ServiceName}}.{{.Name}}", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+{{end}}`))
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerate(t *testing.T) {
+	dir := filepath.Join("testdata", "service")
+	g := &generator{dir: dir, typeName: "Service", serviceName: "service"}
+	data, err := g.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 生成的文件和源码放在一起，再次生成时要跳过它
+	golden := filepath.Join(dir, "service_brpc.go")
+	if *update {
+		if err := os.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("generated code differs from %v, run go test -update to regenerate\n%s", golden, data)
+	}
+}
+
+func TestGenerateNoMethods(t *testing.T) {
+	g := &generator{dir: filepath.Join("testdata", "service"), typeName: "Req", serviceName: "req"}
+	if _, err := g.generate(); err == nil {
+		t.Fatal("expected error for type without methods")
+	}
+}
@@ -0,0 +1,56 @@
+// brpcgen 根据注册到brpc.Server的接收者类型生成带类型的客户端，一般通过go generate使用:
+//
+//	//go:generate go run github.com/abxuz/b-tools/v2/brpc/cmd/brpcgen --type Service --name service
+//
+// 生成的文件里有ServiceClient接口和NewServiceClient，接口可以在测试中替换成mock。
+// 方法的筛选规则和Server.Register一样，T和*T上的方法都会被生成，
+// 所以服务端应该注册指针。
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var (
+		dir      string
+		typeName string
+		name     string
+		output   string
+	)
+
+	c := &cobra.Command{
+		Use:   filepath.Base(os.Args[0]),
+		Short: "generate typed brpc clients",
+		Run: func(cmd *cobra.Command, args []string) {
+			if name == "" {
+				name = typeName
+			}
+			if output == "" {
+				output = filepath.Join(dir, strings.ToLower(typeName)+"_brpc.go")
+			}
+
+			g := &generator{dir: dir, typeName: typeName, serviceName: name}
+			data, err := g.generate()
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+
+			if err := os.WriteFile(output, data, 0644); err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+		},
+	}
+	c.Flags().StringVar(&dir, "dir", ".", "package directory")
+	c.Flags().StringVar(&typeName, "type", "", "receiver type registered to the server")
+	c.Flags().StringVar(&name, "name", "", "service name passed to RegisterName, defaults to the type name")
+	c.Flags().StringVarP(&output, "output", "o", "", "output file, defaults to <type>_brpc.go")
+	c.MarkFlagRequired("type")
+	c.Execute()
+}
@@ -0,0 +1,10 @@
+//go:build ignore
+
+// go run gen.go 这类文件通常是别的包
+package main
+
+type Service struct{}
+
+func (s *Service) Ignored(req string, resp *string) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"time"
+
+	key "github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+type Service struct{}
+
+type Req struct {
+	Name string
+}
+
+type Resp struct {
+	Greeting string
+	At       time.Time
+}
+
+func (s *Service) Hello(req Req, resp *Resp) error {
+	return nil
+}
+
+func (s *Service) PublicKey(req key.NoisePrivateKey, resp *key.NoisePublicKey) error {
+	return nil
+}
+
+func (s *Service) Sum(nums []int, resp *int) error {
+	return nil
+}
+
+// 不符合Register规则的方法不生成
+func (s *Service) Close() error {
+	return nil
+}
+
+func (s *Service) hidden(req Req, resp *Resp) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+// Code generated by brpcgen. DO NOT EDIT.
+
+package service
+
+import (
+	"context"
+
+	key "github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+// ServiceClient 是Service的客户端
+type ServiceClient interface {
+	Hello(ctx context.Context, req Req) (*Resp, error)
+	PublicKey(ctx context.Context, req key.NoisePrivateKey) (*key.NoisePublicKey, error)
+	Sum(ctx context.Context, req []int) (*int, error)
+}
+
+type serviceClient struct {
+	client brpc.RpcClient
+}
+
+func NewServiceClient(client brpc.RpcClient) ServiceClient {
+	return &serviceClient{client: client}
+}
+
+func (c *serviceClient) Hello(ctx context.Context, req Req) (*Resp, error) {
+	resp := new(Resp)
+	if err := c.client.CallContext(ctx, "service.Hello", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *serviceClient) PublicKey(ctx context.Context, req key.NoisePrivateKey) (*key.NoisePublicKey, error) {
+	resp := new(key.NoisePublicKey)
+	if err := c.client.CallContext(ctx, "service.PublicKey", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *serviceClient) Sum(ctx context.Context, req []int) (*int, error) {
+	resp := new(int)
+	if err := c.client.CallContext(ctx, "service.Sum", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
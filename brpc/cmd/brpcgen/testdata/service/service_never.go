@@ -0,0 +1,8 @@
+//go:build never
+
+package service
+
+// 构建约束排除的文件不参与生成
+func (s *Service) Excluded(req Req, resp *Resp) error {
+	return nil
+}
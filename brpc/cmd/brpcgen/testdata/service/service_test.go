@@ -0,0 +1,7 @@
+package service_test
+
+type Service struct{}
+
+func (s *Service) Test(req string, resp *string) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package brpc
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec 负责请求和响应body的编码，客户端选用的编码方式通过消息头部中的ID告诉服务端，
+// 服务端用同样的方式解码请求和编码响应。错误信息的编码不受影响。
+type Codec interface {
+	// ID 是消息头部中标识编码方式的字节，0是默认的msgpack
+	ID() byte
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+const (
+	CodecMsgpack byte = iota
+	CodecJSON
+	CodecCBOR
+)
+
+var (
+	MsgpackCodec Codec = msgpackCodec{}
+	JSONCodec    Codec = jsonCodec{}
+	CBORCodec    Codec = cborCodec{}
+)
+
+var (
+	codecs     = map[byte]Codec{}
+	codecsLock sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(MsgpackCodec)
+	RegisterCodec(JSONCodec)
+	RegisterCodec(CBORCodec)
+}
+
+// RegisterCodec 注册服务端可以识别的编码方式，ID相同时覆盖之前注册的
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[codec.ID()] = codec
+}
+
+func getCodec(id byte) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, Errorf(CodeUnimplemented, "brpc: unsupported codec %v", id)
+	}
+	return codec, nil
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte                           { return CodecMsgpack }
+func (msgpackCodec) Name() string                       { return "msgpack" }
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte                           { return CodecJSON }
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type cborCodec struct{}
+
+func (cborCodec) ID() byte                           { return CodecCBOR }
+func (cborCodec) Name() string                       { return "cbor" }
+func (cborCodec) Marshal(v any) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
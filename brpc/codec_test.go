@@ -0,0 +1,89 @@
+package brpc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	bhttp "github.com/abxuz/b-tools/v2/brpc/http"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+// unknownCodec 是服务端没有注册的编码方式
+type unknownCodec struct{ brpc.Codec }
+
+func (unknownCodec) ID() byte { return 99 }
+
+func TestCodecs(t *testing.T) {
+	ts := newTestServer(t)
+	ts.HandleStream("echo", func(st *brpc.ServerStream) error {
+		var r Req
+		for st.Recv(&r) == nil {
+			st.Send(Resp{Age: len(r.Name)})
+		}
+		return nil
+	})
+
+	for _, codec := range []brpc.Codec{brpc.MsgpackCodec, brpc.JSONCodec, brpc.CBORCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			clients := map[string]brpc.RpcClient{
+				"http": ts.httpClient(t, bhttp.WithCodec(codec)),
+			}
+			for _, mode := range clientModes {
+				clients[mode.name] = ts.client(t, append(mode.opts, rw.WithCodec(codec))...)
+			}
+			for name, c := range clients {
+				var r Resp
+				if err := c.Call("service.Query", Req{Name: "admin"}, &r); err != nil || r.Age != 100 {
+					t.Fatal(name, err, r)
+				}
+				if err := c.Call("service.Query", Req{Name: "x"}, &r); err == nil || err.Error() != "unknown name" {
+					t.Fatal(name, err)
+				}
+			}
+
+			st, err := ts.client(t, rw.WithMux(), rw.WithCodec(codec)).NewStream(context.Background(), "echo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			st.Send(Req{Name: "abc"})
+			st.CloseSend()
+			var r Resp
+			if err := st.Recv(&r); err != nil || r.Age != 3 {
+				t.Fatal(err, r)
+			}
+			if err := st.Recv(&r); err != io.EOF {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.httpClient(t, bhttp.WithCodec(unknownCodec{brpc.JSONCodec}))
+	var r Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &r); brpc.ErrorCodeOf(err) != brpc.CodeUnimplemented {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownCodecShortCircuit(t *testing.T) {
+	// 拦截器不调用next直接给出响应时，服务端也不能用不存在的codec编码响应
+	ts := newTestServer(t, brpc.WithInterceptors(func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		call.Response = &Resp{Age: 1}
+		return nil
+	}))
+
+	var r Resp
+	err := ts.client(t, rw.WithCodec(unknownCodec{brpc.JSONCodec})).Call("service.Query", Req{Name: "admin"}, &r)
+	if brpc.ErrorCodeOf(err) != brpc.CodeUnimplemented {
+		t.Fatal(err)
+	}
+
+	if err := ts.client(t).Call("service.Query", Req{Name: "admin"}, &r); err != nil || r.Age != 1 {
+		t.Fatal(err, r)
+	}
+}
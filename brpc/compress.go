@@ -0,0 +1,95 @@
+package brpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"slices"
+	"sync"
+)
+
+// 压缩方式，0表示不压缩
+const (
+	CompressionFlate byte = 1
+)
+
+const DefaultMaxDecompressedSize = 16 << 20
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// compression 是一端的压缩设置。只压缩body，并且只在对方通过头部表示可以解压时才压缩，
+// 所以任何一端都可以不开启压缩。流上的消息不压缩。
+type compression struct {
+	// 编码后的body达到threshold字节才压缩
+	threshold int
+	// 解压后的最大长度，防止解压炸弹
+	maxSize int
+}
+
+func newCompression(threshold int, maxSize int) *compression {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+	return &compression{threshold: threshold, maxSize: maxSize}
+}
+
+// accept 返回可以解压的压缩方式，没有开启压缩时返回nil
+func (c *compression) accept() []byte {
+	if c == nil {
+		return nil
+	}
+	return []byte{CompressionFlate}
+}
+
+// compress 在对方可以解压并且数据足够长时压缩，返回使用的压缩方式，
+// 压缩后没有变小时不压缩
+func (c *compression) compress(data []byte, accept []byte) ([]byte, byte) {
+	if c == nil || len(data) < c.threshold || !slices.Contains(accept, CompressionFlate) {
+		return data, 0
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	w.Reset(buffer)
+	if _, err := w.Write(data); err != nil {
+		return data, 0
+	}
+	if err := w.Close(); err != nil {
+		return data, 0
+	}
+
+	if buffer.Len() >= len(data) {
+		return data, 0
+	}
+	return buffer.Bytes(), CompressionFlate
+}
+
+func (c *compression) decompress(data []byte, algorithm byte) ([]byte, error) {
+	if algorithm == 0 {
+		return data, nil
+	}
+
+	if c == nil || algorithm != CompressionFlate {
+		return nil, Errorf(CodeUnimplemented, "brpc: unsupported compression %v", algorithm)
+	}
+
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)*2))
+	n, err := io.Copy(buffer, io.LimitReader(r, int64(c.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(c.maxSize) {
+		return nil, ErrMessageTooLarge
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,78 @@
+package brpc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	bhttp "github.com/abxuz/b-tools/v2/brpc/http"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+type Double struct{}
+
+func (Double) Echo(req string, resp *string) error {
+	*resp = req + req
+	return nil
+}
+
+// 160KB，不压缩时超过老协议64KiB的上限，压缩后远小于上限
+var compressible = strings.Repeat("abcdefgh", 20000)
+
+func TestCompression(t *testing.T) {
+	ts := newTestServer(t)
+	ts.RegisterName("double", Double{})
+	ts.SetCompression(100, 1<<20)
+
+	c := ts.client(t, rw.WithLegacyProtocol(), rw.WithCompression(100, 0))
+	var out string
+	// 第一次调用之后客户端才知道服务端可以解压
+	if err := c.Call("double.Echo", "small", &out); err != nil || out != "smallsmall" {
+		t.Fatal(err, out)
+	}
+	if err := c.Call("double.Echo", compressible, &out); err != nil || out != compressible+compressible {
+		t.Fatal(err)
+	}
+
+	// 没有开启压缩的客户端收到的响应不压缩
+	plain := ts.httpClient(t)
+	if err := plain.Call("double.Echo", compressible, &out); err != nil || out != compressible+compressible {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressionServerOff(t *testing.T) {
+	ts := newTestServer(t)
+	ts.RegisterName("double", Double{})
+
+	// 服务端没有开启压缩时客户端不压缩，超过老协议的上限
+	c := ts.client(t, rw.WithLegacyProtocol(), rw.WithCompression(100, 0))
+	var out string
+	if err := c.Call("double.Echo", "small", &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Call("double.Echo", compressible, &out); err != brpc.ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	ts := newTestServer(t)
+	ts.RegisterName("double", Double{})
+	ts.SetCompression(1, 1<<20)
+
+	// 客户端解压后的响应超过上限
+	hc := ts.httpClient(t, bhttp.WithCompression(10, 100))
+	var out string
+	hc.Call("double.Echo", "x", &out)
+	if err := hc.Call("double.Echo", compressible, &out); err != brpc.ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+
+	// 服务端解压后的请求超过上限
+	c := ts.client(t, rw.WithMux(), rw.WithCompression(1, 0))
+	c.Call("double.Echo", "x", &out)
+	if err := c.Call("double.Echo", strings.Repeat("a", 2<<20), &out); err == nil || err.Error() != brpc.ErrMessageTooLarge.Error() {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+package brpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+type DeadlineReq struct {
+	brpc.Caller
+}
+
+type Deadline struct{}
+
+// Get 返回处理请求的ctx剩余的毫秒数，没有截止时间时返回-1
+func (Deadline) Get(req DeadlineReq, resp *int64) error {
+	*resp = -1
+	if d, ok := req.Context().Deadline(); ok {
+		*resp = time.Until(d).Milliseconds()
+	}
+	return nil
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.RegisterName("dl", Deadline{})
+
+	clients := map[string]brpc.RpcClient{"http": ts.httpClient(t)}
+	for _, mode := range clientModes {
+		clients[mode.name] = ts.client(t, mode.opts...)
+	}
+	for name, c := range clients {
+		var out int64
+		if err := c.Call("dl.Get", DeadlineReq{}, &out); err != nil || out != -1 {
+			t.Fatal(name, err, out)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := c.CallContext(ctx, "dl.Get", DeadlineReq{}, &out)
+		cancel()
+		if err != nil || out < 1000 || out > 2000 {
+			t.Fatal(name, err, out)
+		}
+
+		// 已经结束的ctx不会发出请求
+		var resp Resp
+		if err := c.CallContext(ctx, "service.Query", Req{Name: "admin"}, &resp); err == nil {
+			t.Fatal(name, "expected error")
+		}
+	}
+}
+
+func TestDeadlineCancelsHandler(t *testing.T) {
+	ts := newTestServer(t)
+	done := make(chan struct{})
+	brpc.HandleFunc(ts.Server, "wait.Done", func(ctx context.Context, req *int) (*int, error) {
+		<-ctx.Done()
+		close(done)
+		return req, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var out int
+	if err := ts.client(t).CallContext(ctx, "wait.Done", 1, &out); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	// 客户端超时或者断开连接都会结束处理函数的ctx
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler ctx not done")
+	}
+}
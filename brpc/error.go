@@ -0,0 +1,123 @@
+package brpc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type ErrorCode int
+
+// 预定义的错误码，业务可以使用其他值
+const (
+	CodeUnknown ErrorCode = iota
+	CodeInvalidArgument
+	CodeNotFound
+	CodeAlreadyExists
+	CodePermissionDenied
+	CodeFailedPrecondition
+	CodeResourceExhausted
+	CodeDeadlineExceeded
+	CodeUnavailable
+	CodeUnimplemented
+	CodeInternal
+)
+
+func (e ErrorCode) String() string {
+	switch e {
+	case CodeUnknown:
+		return "unknown"
+	case CodeInvalidArgument:
+		return "invalid_argument"
+	case CodeNotFound:
+		return "not_found"
+	case CodeAlreadyExists:
+		return "already_exists"
+	case CodePermissionDenied:
+		return "permission_denied"
+	case CodeFailedPrecondition:
+		return "failed_precondition"
+	case CodeResourceExhausted:
+		return "resource_exhausted"
+	case CodeDeadlineExceeded:
+		return "deadline_exceeded"
+	case CodeUnavailable:
+		return "unavailable"
+	case CodeUnimplemented:
+		return "unimplemented"
+	case CodeInternal:
+		return "internal"
+	default:
+		return "code_" + strconv.Itoa(int(e))
+	}
+}
+
+// Error 是带错误码的错误，处理函数返回它时客户端会收到同样的Code、Message和Details，
+// 可以用errors.As取出来。老版本的客户端只能收到Message，表现为LogicError。
+type Error struct {
+	Code    ErrorCode `msgpack:"c"`
+	Message string    `msgpack:"m"`
+	// msgpack编码的附加信息
+	Details msgpack.RawMessage `msgpack:"d,omitempty"`
+	// 大于0时表示可以在这段时间之后重试，见RetryAfter
+	RetryAfter time.Duration `msgpack:"ra,omitempty"`
+}
+
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// WithDetails 返回附带了details的新错误，details用msgpack编码
+func (e *Error) WithDetails(details any) (*Error, error) {
+	data, err := msgpack.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+
+	err2 := *e
+	err2.Details = data
+	return &err2, nil
+}
+
+// DecodeDetails 把附加信息解码到v中，没有附加信息时不修改v
+func (e *Error) DecodeDetails(v any) error {
+	if len(e.Details) == 0 {
+		return nil
+	}
+	return msgpack.Unmarshal(e.Details, v)
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is 让Code和Message都相同的错误可以用errors.Is判断，例如errors.Is(err, brpc.ErrRateLimited)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Message == e.Message
+}
+
+// ErrorCodeOf 返回err中的错误码，err为nil或者不带错误码时返回CodeUnknown
+func ErrorCodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeUnknown
+}
+
+// RetryAfter 返回服务端建议的重试等待时间，err不可以重试时返回false
+func RetryAfter(err error) (time.Duration, bool) {
+	var e *Error
+	if errors.As(err, &e) && e.RetryAfter > 0 {
+		return e.RetryAfter, true
+	}
+	return 0, false
+}
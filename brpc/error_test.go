@@ -0,0 +1,69 @@
+package brpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestTypedError(t *testing.T) {
+	ts := newTestServer(t, brpc.WithInterceptors(func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		if call.ServiceName == "service.Query" {
+			e, _ := brpc.NewError(brpc.CodeNotFound, "nope").WithDetails(map[string]int{"id": 42})
+			return e
+		}
+		return next(ctx, call)
+	}))
+	ts.HandleStream("s.x", func(st *brpc.ServerStream) error {
+		return brpc.Errorf(brpc.CodeInvalidArgument, "bad %d", 1)
+	})
+
+	clients := map[string]brpc.RpcClient{
+		"mux":  ts.client(t, rw.WithMux()),
+		"http": ts.httpClient(t),
+	}
+	for name, c := range clients {
+		var r Resp
+		err := c.Call("service.Query", Req{}, &r)
+		var e *brpc.Error
+		if !errors.As(err, &e) || e.Code != brpc.CodeNotFound || e.Message != "nope" {
+			t.Fatal(name, err)
+		}
+		var d map[string]int
+		if err := e.DecodeDetails(&d); err != nil || d["id"] != 42 {
+			t.Fatal(name, err, d)
+		}
+		if !errors.Is(err, brpc.NewError(brpc.CodeNotFound, "nope")) {
+			t.Fatal(name, "errors.Is")
+		}
+	}
+
+	st, err := ts.client(t, rw.WithMux()).NewStream(context.Background(), "s.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	err = st.Recv(nil)
+	if brpc.ErrorCodeOf(err) != brpc.CodeInvalidArgument || err.Error() != "bad 1" {
+		t.Fatal(err)
+	}
+}
+
+func TestErrorCodeOf(t *testing.T) {
+	if code := brpc.ErrorCodeOf(nil); code != brpc.CodeUnknown {
+		t.Fatal(code)
+	}
+	if code := brpc.ErrorCodeOf(errors.New("x")); code != brpc.CodeUnknown {
+		t.Fatal(code)
+	}
+	wrapped := errors.Join(errors.New("x"), brpc.NewError(brpc.CodeInternal, "y"))
+	if code := brpc.ErrorCodeOf(wrapped); code != brpc.CodeInternal {
+		t.Fatal(code)
+	}
+	if s := brpc.ErrorCode(100).String(); s != "code_100" {
+		t.Fatal(s)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+	"github.com/spf13/cobra"
+)
+
+func NewClientCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "client",
+		Short: "client demo",
+		Run: func(cmd *cobra.Command, args []string) {
+			var clientPrivKey bcrypt.NoisePrivateKey
+			clientPrivKey.FromString("iBv818sWwMDjU/IdvVyb2hAvlTrm6S/xf9oSFySEVnw=")
+
+			var serverPubKey bcrypt.NoisePublicKey
+			serverPubKey.FromString("7S7lkXbp3Xomf9WdCbvL68hxEcdGxT4X+Wco4gKa2CM=")
+
+			rpcClient := rw.NewClient(
+				rw.WithClientPrivateKey(clientPrivKey),
+				rw.WithServerPublicKey(serverPubKey),
+				rw.WithMux(),
+				rw.WithOpen(func() (io.ReadWriteCloser, error) {
+					return net.Dial("tcp", "127.0.0.1:10002")
+				}),
+			)
+			defer rpcClient.Close()
+
+			var resp *QueryResponse
+			err := rpcClient.Call("service.Query", QueryRequest{Name: "123"}, &resp)
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				cmd.PrintErrln("unexpected result")
+				os.Exit(1)
+			}
+
+			err = rpcClient.Call("service.Query", QueryRequest{Name: "admin"}, &resp)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+
+			fmt.Println(resp)
+		},
+	}
+	return c
+}
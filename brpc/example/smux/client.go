@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -43,17 +44,15 @@ func NewClientCmd() *cobra.Command {
 				rw.WithClientPrivateKey(clientPrivKey),
 				rw.WithServerPublicKey(serverPubKey),
 				rw.WithOpen(func() (io.ReadWriteCloser, error) {
-					stream, err := session.OpenStream()
-					if err != nil {
-						return nil, err
-					}
-					stream.SetDeadline(time.Now().Add(time.Second * 3))
-					return stream, nil
+					return session.OpenStream()
 				}),
 			)
 
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+
 			var resp *QueryResponse
-			err = rpcClient.Call("service.Query", QueryRequest{Name: "123"}, &resp)
+			err = rpcClient.CallContext(ctx, "service.Query", QueryRequest{Name: "123"}, &resp)
 			if err != nil {
 				fmt.Println(err)
 			} else {
@@ -61,7 +60,7 @@ func NewClientCmd() *cobra.Command {
 				os.Exit(1)
 			}
 
-			err = rpcClient.Call("service.Query", QueryRequest{Name: "admin"}, &resp)
+			err = rpcClient.CallContext(ctx, "service.Query", QueryRequest{Name: "admin"}, &resp)
 			if err != nil {
 				cmd.PrintErrln(err)
 				os.Exit(1)
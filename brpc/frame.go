@@ -0,0 +1,166 @@
+package brpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+// 多路复用模式下，连接建立后客户端先发送 frameMagic + 版本号，
+// 服务端校验后原样回复，之后双方都以帧为单位收发数据。
+// 老客户端一上来就发送32字节的临时公钥，服务端靠 frameMagic 区分两者。
+const (
+	FrameVersion1 byte = 1 // 数据长度uint16
+	FrameVersion2 byte = 2 // 数据长度uint32
+)
+
+const (
+	legacyMaxMessageSize  = math.MaxUint16
+	DefaultMaxMessageSize = 4 << 20
+)
+
+const (
+	FrameRequest byte = iota + 1
+	FrameResponse
+	FrameReject
+
+	// 流式调用，客户端用StreamOpen打开流，之后双方用StreamData发送消息，
+	// 客户端用StreamEnd表示不再发送，服务端用StreamEnd返回最终结果，
+	// 客户端可以随时用StreamCancel中止流
+	FrameStreamOpen
+	FrameStreamData
+	FrameStreamEnd
+	FrameStreamCancel
+)
+
+var frameMagic = [4]byte{'b', 'R', 'P', 'C'}
+
+var (
+	ErrFrameMagic   = errors.New("invalid frame magic")
+	ErrFrameVersion = errors.New("unsupported frame version")
+	ErrFrameType    = errors.New("invalid frame type")
+
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+type Frame struct {
+	Type    byte
+	ID      uint32
+	EPubKey bcrypt.NoisePublicKey
+	T       int64
+	Data    []byte
+}
+
+func WriteHandshake(w io.Writer, version byte) error {
+	var buff [5]byte
+	copy(buff[:], frameMagic[:])
+	buff[4] = version
+	_, err := w.Write(buff[:])
+	return err
+}
+
+func ReadHandshake(r io.Reader) (version byte, err error) {
+	var buff [5]byte
+	if _, err = io.ReadFull(r, buff[:]); err != nil {
+		return 0, err
+	}
+	if [4]byte(buff[:4]) != frameMagic {
+		return 0, ErrFrameMagic
+	}
+	version = buff[4]
+	return version, checkFrameVersion(version)
+}
+
+func checkFrameVersion(version byte) error {
+	switch version {
+	case FrameVersion1, FrameVersion2:
+		return nil
+	}
+	return ErrFrameVersion
+}
+
+func frameLenSize(version byte) int {
+	if version == FrameVersion1 {
+		return 2
+	}
+	return 4
+}
+
+func frameMaxDataLen(version byte) int64 {
+	if version == FrameVersion1 {
+		return math.MaxUint16
+	}
+	return math.MaxUint32
+}
+
+// 帧格式: type(1) + id(4) + ePubKey(32) + t(8) + len(2或4，取决于版本) + data
+func WriteFrame(w io.Writer, version byte, f *Frame) error {
+	if int64(len(f.Data)) > frameMaxDataLen(version) {
+		return ErrMessageTooLarge
+	}
+
+	buff := make([]byte, 0, 45+frameLenSize(version)+len(f.Data))
+	buff = append(buff, f.Type)
+	buff = binary.BigEndian.AppendUint32(buff, f.ID)
+	buff = append(buff, f.EPubKey[:]...)
+	buff = binary.BigEndian.AppendUint64(buff, uint64(f.T))
+	if version == FrameVersion1 {
+		buff = binary.BigEndian.AppendUint16(buff, uint16(len(f.Data)))
+	} else {
+		buff = binary.BigEndian.AppendUint32(buff, uint32(len(f.Data)))
+	}
+	buff = append(buff, f.Data...)
+	_, err := w.Write(buff)
+	return err
+}
+
+// ReadFrame 读取一帧，数据超过maxSize时会丢弃数据部分并返回ErrMessageTooLarge，
+// 此时帧头已经读出，连接仍然可以继续使用
+func ReadFrame(r io.Reader, version byte, maxSize int, f *Frame) error {
+	var head [49]byte
+	headLen := 45 + frameLenSize(version)
+	if _, err := io.ReadFull(r, head[:headLen]); err != nil {
+		return err
+	}
+
+	f.Type = head[0]
+	switch f.Type {
+	case FrameRequest, FrameResponse, FrameReject,
+		FrameStreamOpen, FrameStreamData, FrameStreamEnd, FrameStreamCancel:
+	default:
+		return ErrFrameType
+	}
+
+	f.ID = binary.BigEndian.Uint32(head[1:5])
+	copy(f.EPubKey[:], head[5:37])
+	f.T = int64(binary.BigEndian.Uint64(head[37:45]))
+
+	var dataLen int64
+	if version == FrameVersion1 {
+		dataLen = int64(binary.BigEndian.Uint16(head[45:47]))
+	} else {
+		dataLen = int64(binary.BigEndian.Uint32(head[45:49]))
+	}
+
+	if dataLen > int64(maxSize) {
+		f.Data = f.Data[:0]
+		_, err := io.CopyN(io.Discard, r, dataLen)
+		if err != nil {
+			return err
+		}
+		return ErrMessageTooLarge
+	}
+
+	if int64(cap(f.Data)) < dataLen {
+		f.Data = make([]byte, dataLen)
+	}
+	f.Data = f.Data[:dataLen]
+	_, err := io.ReadFull(r, f.Data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package brpc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, version := range []byte{brpc.FrameVersion1, brpc.FrameVersion2} {
+		buf := new(bytes.Buffer)
+		if err := brpc.WriteHandshake(buf, version); err != nil {
+			t.Fatal(err)
+		}
+
+		in := &brpc.Frame{Type: brpc.FrameRequest, ID: 7, T: 1234, Data: []byte("hello")}
+		in.EPubKey[0] = 1
+		if err := brpc.WriteFrame(buf, version, in); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := brpc.ReadHandshake(buf)
+		if err != nil || got != version {
+			t.Fatal(got, err)
+		}
+		out := new(brpc.Frame)
+		if err := brpc.ReadFrame(buf, version, 1024, out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Type != in.Type || out.ID != in.ID || out.T != in.T || out.EPubKey != in.EPubKey || string(out.Data) != "hello" {
+			t.Fatalf("version %v: got %+v", version, out)
+		}
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	buf := new(bytes.Buffer)
+	brpc.WriteFrame(buf, brpc.FrameVersion2, &brpc.Frame{Type: brpc.FrameRequest, ID: 1, Data: make([]byte, 100)})
+	brpc.WriteFrame(buf, brpc.FrameVersion2, &brpc.Frame{Type: brpc.FrameRequest, ID: 2, Data: []byte("ok")})
+
+	// 超过上限的帧被跳过，后面的帧仍然可以读
+	f := new(brpc.Frame)
+	if err := brpc.ReadFrame(buf, brpc.FrameVersion2, 10, f); err != brpc.ErrMessageTooLarge || f.ID != 1 {
+		t.Fatal(err, f.ID)
+	}
+	if err := brpc.ReadFrame(buf, brpc.FrameVersion2, 10, f); err != nil || f.ID != 2 || string(f.Data) != "ok" {
+		t.Fatal(err, f.ID)
+	}
+
+	if err := brpc.WriteFrame(buf, brpc.FrameVersion1, &brpc.Frame{Data: make([]byte, 1<<16)}); err != brpc.ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+}
+
+func TestFrameInvalid(t *testing.T) {
+	if _, err := brpc.ReadHandshake(bytes.NewReader([]byte("xRPC\x01"))); err != brpc.ErrFrameMagic {
+		t.Fatal(err)
+	}
+	if _, err := brpc.ReadHandshake(bytes.NewReader([]byte("bRPC\x09"))); err != brpc.ErrFrameVersion {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	brpc.WriteFrame(buf, brpc.FrameVersion2, &brpc.Frame{Type: 99})
+	if err := brpc.ReadFrame(buf, brpc.FrameVersion2, 10, new(brpc.Frame)); err != brpc.ErrFrameType {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+package brpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 扩展头部放在加密数据的末尾: msgpack(header) + len(uint16) + headerMagic。
+// 老版本的服务端用msgpack解码body时会忽略后面多出来的数据，所以新客户端可以放心带上它。
+// 服务端无法事先知道客户端的版本，只能根据末尾的headerMagic判断。headerMagic以msgpack中
+// 从未使用的0xc1开头，但str和bin类型的数据可以包含任意字节，老客户端的body仍有可能恰好以它结尾，
+// 所以末尾的数据解析不出头部时按没有头部处理，只有长度和msgpack编码都恰好合法时才会误判，
+// 这个概率可以忽略。
+var headerMagic = [4]byte{0xc1, 'b', 'x', 0x01}
+
+var errInvalidHeader = errors.New("invalid message header")
+
+// headerVersion 是客户端支持的响应格式版本，服务端只会给支持的客户端发送新格式的响应
+//
+//	1: 支持带错误码的错误(0xfc)
+//	2: 响应末尾也带扩展头部
+const headerVersion = 2
+
+type header struct {
+	Version int `msgpack:"v,omitempty"`
+	// body的编码方式，见Codec.ID
+	Codec byte `msgpack:"c,omitempty"`
+	// 客户端剩余的超时时间，毫秒
+	Timeout int64 `msgpack:"t,omitempty"`
+	// body的压缩方式，0表示没有压缩
+	Compression byte `msgpack:"z,omitempty"`
+	// 发送方可以解压的压缩方式，对方只会用这些方式压缩发过来的body
+	AcceptCompression []byte `msgpack:"az,omitempty"`
+	// 请求或响应的metadata，见Metadata
+	Metadata Metadata `msgpack:"md,omitempty"`
+}
+
+func newRequestHeader(ctx context.Context, codec Codec) (*header, error) {
+	h := &header{Version: headerVersion, Codec: codec.ID(), Metadata: OutgoingMetadata(ctx).copyTo(nil)}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return h, nil
+	}
+
+	h.Timeout = time.Until(deadline).Milliseconds()
+	if h.Timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	return h, nil
+}
+
+func writeHeader(buffer *bytes.Buffer, h *header) error {
+	n := buffer.Len()
+	if err := msgpack.NewEncoder(buffer).Encode(h); err != nil {
+		return err
+	}
+
+	size := buffer.Len() - n
+	if size > 0xffff {
+		return errInvalidHeader
+	}
+
+	var tail [6]byte
+	binary.BigEndian.PutUint16(tail[:2], uint16(size))
+	copy(tail[2:], headerMagic[:])
+	buffer.Write(tail[:])
+	return nil
+}
+
+// splitHeader 从数据末尾拆出扩展头部，没有扩展头部时返回的header为nil
+func splitHeader(data []byte) ([]byte, *header) {
+	if len(data) < 6 || [4]byte(data[len(data)-4:]) != headerMagic {
+		return data, nil
+	}
+
+	// 解析不出头部时可能是老客户端的body恰好以headerMagic结尾
+	size := int(binary.BigEndian.Uint16(data[len(data)-6:]))
+	end := len(data) - 6
+	if size == 0 || size > end {
+		return data, nil
+	}
+
+	h := new(header)
+	if err := msgpack.Unmarshal(data[end-size:end], h); err != nil {
+		return data, nil
+	}
+	return data[:end-size], h
+}
+
+// codec 返回body的编码方式，没有扩展头部时是msgpack
+func (h *header) codec() (Codec, error) {
+	if h == nil {
+		return MsgpackCodec, nil
+	}
+	return getCodec(h.Codec)
+}
+
+// withTimeout 把客户端的超时时间转换成处理请求用的ctx
+func (h *header) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h == nil || h.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(h.Timeout)*time.Millisecond)
+}
+
+// appendError 生成错误响应，客户端支持时权限错误单独用0xfd标记，方便客户端识别，
+// 带错误码的错误用0xfc标记，其他错误和老版本的客户端只发送错误信息
+func (h *header) appendError(dst []byte, err error) []byte {
+	if h != nil && h.Version >= 1 && errors.Is(err, ErrPermissionDenied) {
+		dst = append(dst, 0xfd)
+		return append(dst, err.Error()...)
+	}
+
+	var e *Error
+	if h != nil && h.Version >= 1 && errors.As(err, &e) {
+		if data, err := msgpack.Marshal(e); err == nil {
+			dst = append(dst, 0xfc)
+			return append(dst, data...)
+		}
+	}
+
+	dst = append(dst, 0xff)
+	return append(dst, err.Error()...)
+}
@@ -0,0 +1,83 @@
+package brpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSplitHeader(t *testing.T) {
+	buffer := bytes.NewBufferString("body")
+	if err := writeHeader(buffer, &header{Version: headerVersion, Timeout: 1500}); err != nil {
+		t.Fatal(err)
+	}
+
+	body, h := splitHeader(buffer.Bytes())
+	if string(body) != "body" || h == nil || h.Version != headerVersion || h.Timeout != 1500 {
+		t.Fatalf("%q %+v", body, h)
+	}
+
+	body, h = splitHeader([]byte("body"))
+	if string(body) != "body" || h != nil {
+		t.Fatalf("%q %+v", body, h)
+	}
+}
+
+// 老客户端的body恰好以headerMagic结尾时要原样当作body
+func TestSplitHeaderLegacyBody(t *testing.T) {
+	cases := [][]byte{
+		append([]byte{0xff, 0xff}, headerMagic[:]...),
+		append([]byte{0x00, 0x00}, headerMagic[:]...),
+		append([]byte("xx\x00\x02"), headerMagic[:]...),
+	}
+	for _, data := range cases {
+		body, h := splitHeader(data)
+		if h != nil || !bytes.Equal(body, data) {
+			t.Fatalf("% x: %q %+v", data, body, h)
+		}
+	}
+}
+
+func TestRequestHeaderTimeout(t *testing.T) {
+	h, err := newRequestHeader(context.Background(), MsgpackCodec)
+	if err != nil || h.Timeout != 0 {
+		t.Fatal(err, h)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	h, err = newRequestHeader(ctx, MsgpackCodec)
+	if err != nil || h.Timeout <= 1000 || h.Timeout > 2000 {
+		t.Fatal(err, h)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := newRequestHeader(ctx, MsgpackCodec); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendError(t *testing.T) {
+	e := NewError(CodeNotFound, "nope")
+	cases := []struct {
+		h    *header
+		err  error
+		kind byte
+	}{
+		// 老客户端只认识0xff
+		{nil, e, 0xff},
+		{nil, ErrPermissionDenied, 0xff},
+		{&header{}, ErrPermissionDenied, 0xff},
+		{&header{Version: headerVersion}, e, 0xfc},
+		{&header{Version: headerVersion}, ErrPermissionDenied, 0xfd},
+		{&header{Version: headerVersion}, errors.New("x"), 0xff},
+	}
+	for _, c := range cases {
+		if data := c.h.appendError(nil, c.err); data[0] != c.kind {
+			t.Errorf("%+v %v: got %x, want %x", c.h, c.err, data[0], c.kind)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package brpc
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type HealthStatus int
+
+const (
+	HealthUnknown HealthStatus = iota
+	HealthServing
+	HealthNotServing
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthServing:
+		return "serving"
+	case HealthNotServing:
+		return "not serving"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthCheck 查询服务端的健康状态，需要用WithHealth开启，和其他方法一样受ACL控制
+var HealthCheck = NewMethod[HealthCheckRequest, HealthCheckResponse]("brpc.Health.Check")
+
+type HealthCheckRequest struct {
+	// 为空时查询整体状态
+	Service string `msgpack:"service,omitempty" json:"service,omitempty"`
+}
+
+type HealthCheckResponse struct {
+	Status HealthStatus `msgpack:"status" json:"status"`
+}
+
+// Health 记录整体和每个服务的健康状态，处理函数可以随时更新，
+// 服务名为空表示整体状态，新建时整体状态为HealthServing
+type Health struct {
+	lock     sync.RWMutex
+	statuses map[string]HealthStatus
+}
+
+func NewHealth() *Health {
+	return &Health{statuses: map[string]HealthStatus{"": HealthServing}}
+}
+
+func (h *Health) SetStatus(service string, status HealthStatus) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.statuses[service] = status
+}
+
+// Status 返回服务的状态，没有设置过的服务返回false
+func (h *Health) Status(service string) (HealthStatus, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	status, ok := h.statuses[service]
+	return status, ok
+}
+
+// Shutdown 把整体和所有服务的状态设为HealthNotServing，一般在停止服务前调用
+func (h *Health) Shutdown() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for service := range h.statuses {
+		h.statuses[service] = HealthNotServing
+	}
+}
+
+// WithHealth 开启内置的健康检查服务，见HealthCheck
+func WithHealth(health *Health) serverOption {
+	return func(s *Server) {
+		s.health = health
+		HealthCheck.Handle(s, func(ctx context.Context, req *HealthCheckRequest) (*HealthCheckResponse, error) {
+			status, ok := health.Status(req.Service)
+			if !ok {
+				return nil, Errorf(CodeNotFound, "brpc: unknown service %v", req.Service)
+			}
+			return &HealthCheckResponse{Status: status}, nil
+		})
+	}
+}
+
+// WithLivenessProbe 让ServeHTTP在path上响应不需要验证身份的GET请求，
+// 整体状态为HealthServing或者没有开启健康检查时返回200，否则返回503
+func WithLivenessProbe(path string) serverOption {
+	return func(s *Server) {
+		s.livenessPath = path
+	}
+}
+
+func (s *Server) serveLiveness(w http.ResponseWriter) {
+	status := HealthServing
+	if s.health != nil {
+		status, _ = s.health.Status("")
+	}
+
+	if status == HealthServing {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(status.String()))
+}
@@ -0,0 +1,56 @@
+package brpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+func TestHealthCheck(t *testing.T) {
+	h := brpc.NewHealth()
+	ts := newTestServer(t, brpc.WithHealth(h))
+	h.SetStatus("service", brpc.HealthServing)
+	c := ts.client(t)
+
+	for service, want := range map[string]brpc.HealthStatus{"": brpc.HealthServing, "service": brpc.HealthServing} {
+		r, err := brpc.HealthCheck.Invoke(context.Background(), c, &brpc.HealthCheckRequest{Service: service})
+		if err != nil || r.Status != want {
+			t.Fatal(service, err, r)
+		}
+	}
+	if _, err := brpc.HealthCheck.Invoke(context.Background(), c, &brpc.HealthCheckRequest{Service: "x"}); brpc.ErrorCodeOf(err) != brpc.CodeNotFound {
+		t.Fatal(err)
+	}
+
+	h.Shutdown()
+	r, err := brpc.HealthCheck.Invoke(context.Background(), c, &brpc.HealthCheckRequest{Service: "service"})
+	if err != nil || r.Status != brpc.HealthNotServing {
+		t.Fatal(err, r)
+	}
+}
+
+func TestLivenessProbe(t *testing.T) {
+	h := brpc.NewHealth()
+	ts := newTestServer(t, brpc.WithHealth(h), brpc.WithLivenessProbe("/healthz"))
+	hs := httptest.NewServer(ts.Server)
+	defer hs.Close()
+
+	get := func() int {
+		resp, err := http.Get(hs.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatal(code)
+	}
+	h.Shutdown()
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Fatal(code)
+	}
+}
@@ -0,0 +1,130 @@
+package brpc_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+	bhttp "github.com/abxuz/b-tools/v2/brpc/http"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+type Service struct{}
+
+type Req struct{ Name string }
+
+type Resp struct{ Age int }
+
+type Blob struct{ Data []byte }
+
+func (s *Service) Query(req Req, resp *Resp) error {
+	if req.Name != "admin" {
+		return errors.New("unknown name")
+	}
+	resp.Age = 100
+	return nil
+}
+
+func (s *Service) Echo(req Blob, resp *Blob) error {
+	resp.Data = req.Data
+	return nil
+}
+
+func (s *Service) Slow(req Req, resp *Resp) error {
+	time.Sleep(200 * time.Millisecond)
+	return nil
+}
+
+// testServer 是在本地端口上监听的服务端，注册了service，客户端密钥已经添加
+type testServer struct {
+	*brpc.Server
+	clientKey bcrypt.NoisePrivateKey
+	serverKey bcrypt.NoisePublicKey
+	listener  net.Listener
+	served    chan error
+}
+
+func newTestServer(t *testing.T, opts ...func(*brpc.Server)) *testServer {
+	t.Helper()
+
+	spk, err := bcrypt.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpk, err := bcrypt.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := brpc.NewServer(opts...)
+	s.SetServerPrivateKey(spk)
+	s.AddClientPublicKey(cpk.PublicKey())
+	if err := s.RegisterName("service", &Service{}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ts := &testServer{
+		Server:    s,
+		clientKey: cpk,
+		serverKey: spk.PublicKey(),
+		listener:  l,
+		served:    make(chan error, 1),
+	}
+	go func() { ts.served <- s.ServeListener(l, nil) }()
+	return ts
+}
+
+func (ts *testServer) dial() (io.ReadWriteCloser, error) {
+	return net.Dial("tcp", ts.listener.Addr().String())
+}
+
+// client 返回连接ts的rw.Client，opts在默认的密钥和连接方式之后生效
+func (ts *testServer) client(t *testing.T, opts ...func(*rw.Client)) *rw.Client {
+	return ts.clientWithKey(t, ts.clientKey, opts...)
+}
+
+func (ts *testServer) clientWithKey(t *testing.T, key bcrypt.NoisePrivateKey, opts ...func(*rw.Client)) *rw.Client {
+	opts = append([]func(*rw.Client){
+		rw.WithClientPrivateKey(key),
+		rw.WithServerPublicKey(ts.serverKey),
+		rw.WithOpen(ts.dial),
+	}, opts...)
+	c := rw.NewClient(opts...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// httpClient 返回通过httptest服务端连接ts的http.Client
+func (ts *testServer) httpClient(t *testing.T, opts ...func(*bhttp.Client)) *bhttp.Client {
+	hs := httptest.NewServer(ts.Server)
+	t.Cleanup(hs.Close)
+
+	opts = append([]func(*bhttp.Client){
+		bhttp.WithClientPrivateKey(ts.clientKey),
+		bhttp.WithServerPublicKey(ts.serverKey),
+		bhttp.WithEndpoint(hs.URL),
+	}, opts...)
+	return bhttp.NewClient(opts...)
+}
+
+// clientModes 是rw.Client的几种连接方式，测试在每种方式下都要通过
+var clientModes = []struct {
+	name string
+	opts []func(*rw.Client)
+}{
+	{"conn", nil},
+	{"legacy", []func(*rw.Client){rw.WithLegacyProtocol()}},
+	{"mux", []func(*rw.Client){rw.WithMux()}},
+	{"pool", []func(*rw.Client){rw.WithPool(2, 0, 0)}},
+}
@@ -47,6 +47,32 @@ func WithEndpoint(endpoint string) option {
 	}
 }
 
+// WithCodec 设置请求和响应body的编码方式，默认是msgpack
+func WithCodec(codec brpc.Codec) option {
+	return func(c *Client) {
+		c.SetCodec(codec)
+	}
+}
+
+// WithCompression 开启压缩，见brpc.Client.SetCompression
+func WithCompression(threshold int, maxSize int) option {
+	return func(c *Client) {
+		c.SetCompression(threshold, maxSize)
+	}
+}
+
+func WithMetrics(metrics brpc.Metrics) option {
+	return func(c *Client) {
+		c.SetMetrics(metrics)
+	}
+}
+
+func WithInterceptors(interceptors ...brpc.ClientInterceptor) option {
+	return func(c *Client) {
+		c.AddInterceptors(interceptors...)
+	}
+}
+
 func WithServerPublicKey(pk bcrypt.NoisePublicKey) option {
 	return func(c *Client) {
 		c.SetServerPublicKey(pk)
@@ -64,12 +90,16 @@ func (c *Client) Call(serviceName string, req any, resp any) error {
 }
 
 func (c *Client) CallContext(ctx context.Context, serviceName string, req any, resp any) error {
+	return c.Client.Invoke(ctx, serviceName, req, resp, c.invoke)
+}
+
+func (c *Client) invoke(ctx context.Context, serviceName string, req any, resp any) error {
 	var (
 		ePubKey bcrypt.NoisePublicKey
 		t       int64
 	)
 
-	data, err := c.Client.WriteRequestMessage(nil, serviceName, req, &ePubKey, &t)
+	data, err := c.Client.WriteRequestMessageContext(ctx, nil, serviceName, req, &ePubKey, &t)
 	if err != nil {
 		return err
 	}
@@ -92,6 +122,9 @@ func (c *Client) CallContext(ctx context.Context, serviceName string, req any, r
 	}
 
 	if response.StatusCode != http.StatusOK {
+		if reason := response.Header.Get("X-Rpc-Error"); reason != "" {
+			return brpc.RejectionError(reason)
+		}
 		return fmt.Errorf("status code: %v, %v", response.StatusCode, response.Status)
 	}
 
@@ -120,5 +153,5 @@ func (c *Client) CallContext(ctx context.Context, serviceName string, req any, r
 	}
 
 	data = buffer.Bytes()
-	return c.Client.ReadResponseMessage(resp, data, &ePubKey, t)
+	return c.Client.ReadResponseMessageContext(ctx, resp, data, &ePubKey, t)
 }
@@ -0,0 +1,39 @@
+package brpc
+
+import "context"
+
+// Call 是服务端处理的一次调用，Request在调用拦截器之前已经解码，解码失败时为nil，
+// Response在调用next之后才有值。流式调用时Stream不为nil，Request和Response始终为nil
+type Call struct {
+	ServiceName string
+	Client      *ClientInfo
+	Request     any
+	Response    any
+	Stream      *ServerStream
+}
+
+type Handler = func(ctx context.Context, call *Call) error
+
+// Interceptor 包装每一次调用，可以在调用next前后做日志、计时、恢复panic、鉴权等处理，
+// 不调用next时请求不会被处理，返回的错误会作为调用结果发给客户端。
+// 没通过访问控制或者请求无法解码时next返回对应的错误，这时不管拦截器返回什么，客户端都只会收到这个错误
+type Interceptor = func(ctx context.Context, call *Call, next Handler) error
+
+type serverOption = func(s *Server)
+
+// WithInterceptors 按顺序添加拦截器，第一个在最外层
+func WithInterceptors(interceptors ...Interceptor) serverOption {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+func (s *Server) intercept(ctx context.Context, call *Call, handler Handler) error {
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.interceptors[i], handler
+		handler = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return handler(ctx, call)
+}
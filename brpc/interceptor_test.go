@@ -0,0 +1,102 @@
+package brpc_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+type Panicker struct{}
+
+func (Panicker) Boom(req int, resp *int) error { panic("boom") }
+
+func TestInterceptorChain(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		order []string
+		log   []string
+	)
+	record := func(s *[]string, v string) {
+		lock.Lock()
+		defer lock.Unlock()
+		*s = append(*s, v)
+	}
+
+	recoverer := func(ctx context.Context, call *brpc.Call, next brpc.Handler) (err error) {
+		record(&order, "recover")
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return next(ctx, call)
+	}
+	logger := func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		record(&order, "log")
+		err := next(ctx, call)
+		record(&log, fmt.Sprintf("%s %v %v %v", call.ServiceName, call.Request, call.Response, err))
+		return err
+	}
+
+	ts := newTestServer(t, brpc.WithInterceptors(recoverer, logger))
+	ts.RegisterName("p", Panicker{})
+	c := ts.client(t)
+
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil || out.Age != 100 {
+		t.Fatal(err, out)
+	}
+	if err := c.Call("service.Query", Req{Name: "x"}, &out); err == nil || err.Error() != "unknown name" {
+		t.Fatal(err)
+	}
+	var i int
+	if err := c.Call("p.Boom", 1, &i); err == nil || err.Error() != "panic: boom" {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(order[:2], ","); got != "recover,log" {
+		t.Fatal("order", got)
+	}
+	want := []string{
+		"service.Query &{admin} &{100} <nil>",
+		"service.Query &{x} <nil> unknown name",
+	}
+	// panic越过了记录日志的拦截器
+	if len(log) != 2 || log[0] != want[0] || log[1] != want[1] {
+		t.Fatal(log)
+	}
+}
+
+func TestInterceptorSeesRequest(t *testing.T) {
+	ts := newTestServer(t, brpc.WithInterceptors(func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		if req, ok := call.Request.(*Req); !ok || req.Name != "admin" {
+			return brpc.ErrPermissionDenied
+		}
+		return next(ctx, call)
+	}))
+	c := ts.client(t)
+
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil || out.Age != 100 {
+		t.Fatal(err)
+	}
+	if err := c.Call("service.Query", Req{Name: "bob"}, &out); err != brpc.ErrPermissionDenied {
+		t.Fatal(err)
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	ts := newTestServer(t, brpc.WithInterceptors(func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		return brpc.NewError(brpc.CodeUnavailable, "maintenance")
+	}))
+
+	var out Resp
+	err := ts.client(t).Call("service.Query", Req{Name: "admin"}, &out)
+	if brpc.ErrorCodeOf(err) != brpc.CodeUnavailable || out.Age != 0 {
+		t.Fatal(err, out)
+	}
+}
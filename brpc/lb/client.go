@@ -0,0 +1,268 @@
+package lb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+var ErrNoEndpoints = errors.New("no endpoints")
+
+// Policy 是选择服务端的方式
+type Policy int
+
+const (
+	// RoundRobin 依次使用每个服务端
+	RoundRobin Policy = iota
+	// LeastInFlight 使用处理中调用最少的服务端
+	LeastInFlight
+	// Random 随机选择服务端
+	Random
+)
+
+const (
+	DefaultMaxFailures = 3
+	DefaultEjectTime   = 10 * time.Second
+)
+
+// Client 把调用分散到多个服务端，每个服务端对应一个brpc.RpcClient，
+// 例如不同endpoint的http.Client或者不同地址的rw.Client。
+//
+// 连续失败多次的服务端会被暂时剔除，一段时间后放一个调用过去试探，见WithEjection，
+// 成功后恢复，失败则再剔除一段时间。所有服务端都被剔除时仍然从全部服务端中选择。
+// 请求确定没有被处理时(连接失败或者服务端正在停止)会换一个服务端重试。
+//
+//	c := lb.NewClient(
+//		lb.WithEndpoints(func(endpoint string) brpc.RpcClient {
+//			return http.NewClient(http.WithEndpoint(endpoint), ...)
+//		}, "http://10.0.0.1/rpc", "http://10.0.0.2/rpc"),
+//		lb.WithPolicy(lb.LeastInFlight),
+//	)
+type Client struct {
+	policy      Policy
+	maxFailures int
+	ejectTime   time.Duration
+
+	lock      sync.Mutex
+	endpoints []*endpoint
+	next      int
+}
+
+var _ brpc.RpcClient = (*Client)(nil)
+
+type endpoint struct {
+	client   brpc.RpcClient
+	inFlight int
+	failures int
+	// 剔除的截止时间，为零值时没有被剔除
+	ejectedUntil time.Time
+	// 剔除时间过了之后只允许一个试探的调用
+	probing bool
+}
+
+type option = func(c *Client)
+
+func NewClient(opts ...option) *Client {
+	c := &Client{
+		maxFailures: DefaultMaxFailures,
+		ejectTime:   DefaultEjectTime,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithClients 添加服务端，每个client对应一个服务端
+func WithClients(clients ...brpc.RpcClient) option {
+	return func(c *Client) {
+		for _, client := range clients {
+			c.endpoints = append(c.endpoints, &endpoint{client: client})
+		}
+	}
+}
+
+// WithEndpoints 用newClient为每个endpoint创建client，见WithClients
+func WithEndpoints(newClient func(endpoint string) brpc.RpcClient, endpoints ...string) option {
+	return func(c *Client) {
+		for _, e := range endpoints {
+			c.endpoints = append(c.endpoints, &endpoint{client: newClient(e)})
+		}
+	}
+}
+
+func WithPolicy(policy Policy) option {
+	return func(c *Client) {
+		c.policy = policy
+	}
+}
+
+// WithEjection 设置连续失败多少次后剔除服务端，以及剔除多久后再试探
+func WithEjection(maxFailures int, ejectTime time.Duration) option {
+	return func(c *Client) {
+		c.maxFailures = maxFailures
+		c.ejectTime = ejectTime
+	}
+}
+
+// Close 关闭所有实现了io.Closer的client
+func (c *Client) Close() error {
+	var err error
+	for _, e := range c.endpoints {
+		if closer, ok := e.client.(io.Closer); ok {
+			if cerr := closer.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+func (c *Client) Call(serviceName string, req any, resp any) error {
+	return c.CallContext(context.Background(), serviceName, req, resp)
+}
+
+func (c *Client) CallContext(ctx context.Context, serviceName string, req any, resp any) error {
+	if len(c.endpoints) == 0 {
+		return ErrNoEndpoints
+	}
+
+	var err error
+	tried := make([]bool, len(c.endpoints))
+	for {
+		i, probe := c.pick(tried)
+		if i < 0 {
+			return err
+		}
+		tried[i] = true
+
+		e := c.endpoints[i]
+		err = e.client.CallContext(ctx, serviceName, req, resp)
+		c.done(e, probe, failed(ctx, err))
+
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+	}
+}
+
+// pick 按策略选择一个没有试过的服务端，返回下标和这次调用是否用来试探被剔除的服务端，
+// 都试过时返回-1
+func (c *Client) pick(tried []bool) (int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	candidates := make([]int, 0, len(c.endpoints))
+	for i, e := range c.endpoints {
+		if !tried[i] && e.available(now) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	// 全部被剔除时不如都试一下
+	if len(candidates) == 0 {
+		for i := range c.endpoints {
+			if !tried[i] {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return -1, false
+	}
+
+	var i int
+	switch c.policy {
+	case LeastInFlight:
+		// 从轮询的位置开始找，处理中的调用数相同时也能分散开
+		start := c.next % len(candidates)
+		c.next++
+		i = candidates[start]
+		for k := 1; k < len(candidates); k++ {
+			j := candidates[(start+k)%len(candidates)]
+			if c.endpoints[j].inFlight < c.endpoints[i].inFlight {
+				i = j
+			}
+		}
+	case Random:
+		i = candidates[rand.IntN(len(candidates))]
+	default:
+		i = candidates[c.next%len(candidates)]
+		c.next++
+	}
+
+	e := c.endpoints[i]
+	e.inFlight++
+	probe := !e.ejectedUntil.IsZero() && !now.Before(e.ejectedUntil) && !e.probing
+	if probe {
+		e.probing = true
+	}
+	return i, probe
+}
+
+// done 记录调用结果，更新服务端的失败次数和剔除状态
+func (c *Client) done(e *endpoint, probe bool, failed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e.inFlight--
+	if probe {
+		e.probing = false
+	}
+	if !failed {
+		e.failures = 0
+		e.ejectedUntil = time.Time{}
+		return
+	}
+
+	e.failures++
+	if c.maxFailures > 0 && e.failures >= c.maxFailures {
+		e.ejectedUntil = time.Now().Add(c.ejectTime)
+	}
+}
+
+// available 服务端没有被剔除，或者剔除时间已过并且还没有试探中的调用
+func (e *endpoint) available(now time.Time) bool {
+	if e.ejectedUntil.IsZero() {
+		return true
+	}
+	return !now.Before(e.ejectedUntil) && !e.probing
+}
+
+// failed 判断调用失败是不是服务端不可用造成的，业务错误和调用方自己结束的调用不算
+func failed(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var (
+		logicErr brpc.LogicError
+		e        *brpc.Error
+	)
+	switch {
+	case errors.As(err, &logicErr):
+		return false
+	case errors.As(err, &e):
+		return e.Code == brpc.CodeUnavailable
+	case errors.Is(err, brpc.ErrPermissionDenied), errors.Is(err, brpc.ErrMessageTooLarge):
+		return false
+	}
+	return true
+}
+
+// retryable 判断请求是否确定没有被服务端处理，可以换一个服务端重试
+func retryable(err error) bool {
+	if errors.Is(err, brpc.ErrServerClosed) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
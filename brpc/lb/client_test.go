@@ -0,0 +1,138 @@
+package lb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+// fakeClient 记录调用次数，down时像连不上服务端一样返回拨号错误
+type fakeClient struct {
+	lock  sync.Mutex
+	calls int
+	down  bool
+	err   error
+}
+
+func (c *fakeClient) Call(serviceName string, req any, resp any) error {
+	return c.CallContext(context.Background(), serviceName, req, resp)
+}
+
+func (c *fakeClient) CallContext(ctx context.Context, serviceName string, req any, resp any) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls++
+	if c.down {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return c.err
+}
+
+func (c *fakeClient) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.calls
+}
+
+func TestPolicies(t *testing.T) {
+	for _, policy := range []Policy{RoundRobin, LeastInFlight, Random} {
+		a, b, dead := &fakeClient{}, &fakeClient{}, &fakeClient{down: true}
+		c := NewClient(WithClients(a, dead, b), WithPolicy(policy), WithEjection(2, time.Hour))
+
+		for i := 0; i < 30; i++ {
+			if err := c.Call("service.Query", nil, nil); err != nil {
+				t.Fatal(policy, err)
+			}
+		}
+		// 连续失败2次后被剔除，失败的调用换服务端重试
+		if dead.count() != 2 || a.count() == 0 || b.count() == 0 {
+			t.Fatal(policy, a.count(), b.count(), dead.count())
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b := &fakeClient{}, &fakeClient{}
+	c := NewClient(WithClients(a, b))
+	for i := 0; i < 10; i++ {
+		c.Call("x", nil, nil)
+	}
+	if a.count() != 5 || b.count() != 5 {
+		t.Fatal(a.count(), b.count())
+	}
+}
+
+func TestEjectionProbe(t *testing.T) {
+	a, dead := &fakeClient{}, &fakeClient{down: true}
+	c := NewClient(WithClients(a, dead), WithEjection(1, 100*time.Millisecond))
+
+	for i := 0; i < 10; i++ {
+		c.Call("x", nil, nil)
+	}
+	if dead.count() != 1 {
+		t.Fatal(dead.count())
+	}
+
+	// 剔除时间过后只放一个调用去试探，失败后再剔除
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		c.Call("x", nil, nil)
+	}
+	if dead.count() != 2 {
+		t.Fatal(dead.count())
+	}
+
+	// 试探成功后恢复
+	time.Sleep(150 * time.Millisecond)
+	dead.lock.Lock()
+	dead.down = false
+	dead.lock.Unlock()
+	for i := 0; i < 10; i++ {
+		c.Call("x", nil, nil)
+	}
+	if dead.count() < 6 {
+		t.Fatal(dead.count())
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	// 业务错误既不重试也不算失败
+	logic := &fakeClient{err: brpc.LogicError("unknown name")}
+	other := &fakeClient{}
+	c := NewClient(WithClients(logic, other), WithEjection(1, time.Hour))
+	if err := c.Call("x", nil, nil); !errors.As(err, new(brpc.LogicError)) {
+		t.Fatal(err)
+	}
+	if other.count() != 0 {
+		t.Fatal("retried", other.count())
+	}
+	c.Call("x", nil, nil)
+	c.Call("x", nil, nil)
+	if logic.count() != 2 {
+		t.Fatal("ejected", logic.count())
+	}
+
+	// 服务端正在停止时可以重试
+	closing := &fakeClient{err: brpc.ErrServerClosed}
+	ok := &fakeClient{}
+	c = NewClient(WithClients(closing, ok))
+	if err := c.Call("x", nil, nil); err != nil || ok.count() != 1 {
+		t.Fatal(err, ok.count())
+	}
+}
+
+func TestAllDown(t *testing.T) {
+	c := NewClient(WithClients(&fakeClient{down: true}, &fakeClient{down: true}))
+	var opErr *net.OpError
+	if err := c.Call("x", nil, nil); !errors.As(err, &opErr) {
+		t.Fatal(err)
+	}
+	if err := NewClient().Call("x", nil, nil); err != ErrNoEndpoints {
+		t.Fatal(err)
+	}
+}
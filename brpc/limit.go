@@ -0,0 +1,185 @@
+package brpc
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+// 超过限制的调用返回的错误，客户端收到的错误带有RetryAfter，可以用errors.Is判断
+var (
+	ErrRateLimited        = NewError(CodeResourceExhausted, "brpc: rate limit exceeded")
+	ErrConcurrencyLimited = NewError(CodeResourceExhausted, "brpc: too many concurrent calls")
+)
+
+// 并发超限时无法知道什么时候会有调用结束，给客户端一个固定的重试等待时间
+const concurrencyRetryAfter = 100 * time.Millisecond
+
+// Limits 是服务端的限流设置，字段为0表示不限制。
+// 每秒调用次数按令牌桶计算，Burst是允许的突发次数，为0时是每秒调用次数向上取整。
+// 限制在验证客户端身份之后检查，流式调用在整个流结束之前都算处理中。
+type Limits struct {
+	// 每个客户端密钥每秒的调用次数
+	ClientRate  float64
+	ClientBurst int
+	// 每个客户端密钥同时处理中的调用数
+	ClientConcurrency int
+
+	// 所有客户端合计每秒的调用次数
+	Rate  float64
+	Burst int
+	// 所有客户端合计同时处理中的调用数
+	Concurrency int
+}
+
+func WithLimits(limits Limits) serverOption {
+	return func(s *Server) {
+		s.limiter = newLimiter(limits)
+	}
+}
+
+type limiter struct {
+	limits Limits
+
+	lock     sync.Mutex
+	global   limitState
+	clients  map[bcrypt.NoisePublicKey]*limitState
+	lastTidy time.Time
+}
+
+type limitState struct {
+	bucket   tokenBucket
+	inFlight int
+}
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{
+		limits:  limits,
+		clients: make(map[bcrypt.NoisePublicKey]*limitState),
+	}
+	l.global.bucket = newTokenBucket(limits.Rate, limits.Burst)
+	return l
+}
+
+// acquire 检查客户端的调用是否超过限制，没有超过时占用一个处理中的名额，
+// 调用结束后要调用返回的release
+func (l *limiter) acquire(pk bcrypt.NoisePublicKey) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.tidy(now)
+
+	client := l.clients[pk]
+	if client == nil {
+		client = &limitState{bucket: newTokenBucket(l.limits.ClientRate, l.limits.ClientBurst)}
+		l.clients[pk] = client
+	}
+
+	if (l.limits.ClientConcurrency > 0 && client.inFlight >= l.limits.ClientConcurrency) ||
+		(l.limits.Concurrency > 0 && l.global.inFlight >= l.limits.Concurrency) {
+		return nil, &Error{
+			Code:       ErrConcurrencyLimited.Code,
+			Message:    ErrConcurrencyLimited.Message,
+			RetryAfter: concurrencyRetryAfter,
+		}
+	}
+
+	// 两个桶都有令牌时才消耗，避免被拒绝的调用占用另一个桶的令牌
+	if wait := max(client.bucket.wait(now), l.global.bucket.wait(now)); wait > 0 {
+		return nil, &Error{
+			Code:       ErrRateLimited.Code,
+			Message:    ErrRateLimited.Message,
+			RetryAfter: wait,
+		}
+	}
+	client.bucket.take()
+	l.global.bucket.take()
+
+	client.inFlight++
+	l.global.inFlight++
+
+	return func() {
+		l.lock.Lock()
+		client.inFlight--
+		l.global.inFlight--
+		l.lock.Unlock()
+	}, nil
+}
+
+// tidy 每分钟清理一次令牌已经满了并且没有处理中调用的客户端
+func (l *limiter) tidy(now time.Time) {
+	if now.Sub(l.lastTidy) < time.Minute {
+		return
+	}
+	l.lastTidy = now
+
+	for pk, client := range l.clients {
+		if client.inFlight == 0 && client.bucket.full(now) {
+			delete(l.clients, pk)
+		}
+	}
+}
+
+// tokenBucket 以rate每秒的速度补充令牌，最多存burst个，rate为0时不限制
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) tokenBucket {
+	if rate <= 0 {
+		return tokenBucket{}
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait 返回还要等多久才有令牌，有令牌时返回0
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+func (b *tokenBucket) take() {
+	if b.rate > 0 {
+		b.tokens--
+	}
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= b.burst
+}
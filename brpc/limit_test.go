@@ -0,0 +1,112 @@
+package brpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestRateLimit(t *testing.T) {
+	ts := newTestServer(t, brpc.WithLimits(brpc.Limits{ClientRate: 2, ClientBurst: 2}))
+	c := ts.client(t, rw.WithMux())
+
+	var out Resp
+	for i := 0; i < 2; i++ {
+		if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := c.Call("service.Query", Req{Name: "admin"}, &out)
+	d, ok := brpc.RetryAfter(err)
+	if !errors.Is(err, brpc.ErrRateLimited) || !ok || d <= 0 || d > 500*time.Millisecond {
+		t.Fatal(err, d)
+	}
+
+	time.Sleep(d)
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockingServer 注册了block.Wait，调用在release关闭之前不会返回，started在每个调用开始时收到一个值
+func blockingServer(t *testing.T, limits brpc.Limits) (ts *testServer, started chan struct{}, release chan struct{}) {
+	ts = newTestServer(t, brpc.WithLimits(limits))
+	started = make(chan struct{}, 10)
+	release = make(chan struct{})
+	brpc.HandleFunc(ts.Server, "block.Wait", func(ctx context.Context, req *int) (*int, error) {
+		started <- struct{}{}
+		<-release
+		return req, nil
+	})
+	return ts, started, release
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	ts, started, release := blockingServer(t, brpc.Limits{Concurrency: 2})
+	c := ts.client(t, rw.WithMux())
+
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var out int
+			errc <- c.Call("block.Wait", 1, &out)
+		}()
+		<-started
+	}
+
+	var out int
+	err := c.Call("block.Wait", 1, &out)
+	if !errors.Is(err, brpc.ErrConcurrencyLimited) {
+		t.Fatal(err)
+	}
+	if _, ok := brpc.RetryAfter(err); !ok {
+		t.Fatal("no retry after")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Call("block.Wait", 1, &out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientConcurrencyLimit(t *testing.T) {
+	ts, started, release := blockingServer(t, brpc.Limits{ClientConcurrency: 1})
+	defer close(release)
+	other, _ := bcrypt.NewPrivateKey()
+	ts.AddClientPublicKey(other.PublicKey())
+
+	c := ts.client(t, rw.WithMux())
+	go func() {
+		var out int
+		c.Call("block.Wait", 1, &out)
+	}()
+	<-started
+
+	var out int
+	if err := c.Call("block.Wait", 1, &out); !errors.Is(err, brpc.ErrConcurrencyLimited) {
+		t.Fatal(err)
+	}
+
+	// 其他客户端不受影响
+	errc := make(chan error, 1)
+	c2 := ts.clientWithKey(t, other)
+	go func() {
+		var out int
+		errc <- c2.Call("block.Wait", 1, &out)
+	}()
+	select {
+	case <-started:
+	case err := <-errc:
+		t.Fatal(err)
+	}
+}
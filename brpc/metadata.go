@@ -0,0 +1,110 @@
+package brpc
+
+import (
+	"context"
+	"strings"
+)
+
+// Metadata 是随请求和响应加密传输的键值对，例如trace id、request id、语言等，
+// 键统一使用小写
+type Metadata map[string]string
+
+func (md Metadata) Get(key string) string {
+	return md[strings.ToLower(key)]
+}
+
+func (md Metadata) Set(key string, value string) {
+	md[strings.ToLower(key)] = value
+}
+
+func (md Metadata) Delete(key string) {
+	delete(md, strings.ToLower(key))
+}
+
+// copyTo 把md复制到dst，键转换成小写，直接用map语法写入的键也能用Get读到
+func (md Metadata) copyTo(dst Metadata) Metadata {
+	if dst == nil && len(md) > 0 {
+		dst = make(Metadata, len(md))
+	}
+	for k, v := range md {
+		dst.Set(k, v)
+	}
+	return dst
+}
+
+type (
+	outgoingMetadataKey struct{}
+	incomingMetadataKey struct{}
+	responseMetadataKey struct{}
+	receiveMetadataKey  struct{}
+)
+
+// NewOutgoingContext 设置客户端在ctx上发起的调用要带给服务端的metadata，替换之前设置的，
+// md会被复制，之后修改md不影响ctx
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingMetadataKey{}, md.copyTo(Metadata{}))
+}
+
+// AppendToOutgoingContext 在客户端要发送的metadata上追加键值对，kv是交替的键和值
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md := OutgoingMetadata(ctx).copyTo(Metadata{})
+	for i := 0; i+1 < len(kv); i += 2 {
+		md.Set(kv[i], kv[i+1])
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, md)
+}
+
+// OutgoingMetadata 返回客户端要发送的metadata，不要直接修改，用AppendToOutgoingContext追加
+func OutgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md
+}
+
+// IncomingMetadata 返回服务端收到的请求metadata，拦截器可以修改
+func IncomingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingMetadataKey{}).(Metadata)
+	if md == nil {
+		return Metadata{}
+	}
+	return md
+}
+
+// ResponseMetadata 返回服务端要随响应发给客户端的metadata，处理函数和拦截器可以修改，
+// 不在服务端处理请求的ctx上时修改不会生效。流式调用的响应不带metadata。
+func ResponseMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(responseMetadataKey{}).(Metadata)
+	if md == nil {
+		return Metadata{}
+	}
+	return md
+}
+
+// ReceiveResponseMetadata 让客户端在ctx上发起的调用把服务端返回的metadata写入md
+//
+//	md := brpc.Metadata{}
+//	err := client.CallContext(brpc.ReceiveResponseMetadata(ctx, md), "user.Get", req, &resp)
+func ReceiveResponseMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, receiveMetadataKey{}, md)
+}
+
+func newIncomingContext(ctx context.Context, h *header) context.Context {
+	md := Metadata{}
+	if h != nil {
+		h.Metadata.copyTo(md)
+	}
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
+
+func newResponseMetadataContext(ctx context.Context) (context.Context, Metadata) {
+	md := Metadata{}
+	return context.WithValue(ctx, responseMetadataKey{}, md), md
+}
+
+func receiveMetadata(ctx context.Context, h *header) {
+	if h == nil || len(h.Metadata) == 0 {
+		return
+	}
+	if md, ok := ctx.Value(receiveMetadataKey{}).(Metadata); ok {
+		h.Metadata.copyTo(md)
+	}
+}
@@ -0,0 +1,113 @@
+package brpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	bhttp "github.com/abxuz/b-tools/v2/brpc/http"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestMetadata(t *testing.T) {
+	var lang string
+	ts := newTestServer(t, brpc.WithInterceptors(func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		lang = brpc.IncomingMetadata(ctx).Get("Lang")
+		brpc.ResponseMetadata(ctx).Set("X-Server", "yes")
+		return next(ctx, call)
+	}))
+
+	clients := map[string]brpc.RpcClient{"http": ts.httpClient(t)}
+	for _, mode := range clientModes {
+		clients[mode.name] = ts.client(t, mode.opts...)
+	}
+	for name, c := range clients {
+		lang = ""
+		md := brpc.Metadata{}
+		ctx := brpc.ReceiveResponseMetadata(brpc.AppendToOutgoingContext(context.Background(), "lang", "zh"), md)
+		var out Resp
+		if err := c.CallContext(ctx, "service.Query", Req{Name: "admin"}, &out); err != nil {
+			t.Fatal(name, err)
+		}
+		if lang != "zh" || md.Get("x-server") != "yes" {
+			t.Fatal(name, lang, md)
+		}
+	}
+}
+
+// 直接用map语法写入的键也要转换成小写
+func TestMetadataKeyCase(t *testing.T) {
+	var got string
+	ts := newTestServer(t, brpc.WithInterceptors(func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		got = brpc.IncomingMetadata(ctx).Get("X-Request-Id")
+		brpc.ResponseMetadata(ctx)["X-Resp"] = "r"
+		return next(ctx, call)
+	}))
+
+	md := brpc.Metadata{}
+	ctx := brpc.ReceiveResponseMetadata(brpc.NewOutgoingContext(context.Background(), brpc.Metadata{"X-Request-Id": "abc"}), md)
+	var out Resp
+	if err := ts.client(t).CallContext(ctx, "service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got != "abc" || md.Get("X-Resp") != "r" || md["x-resp"] != "r" {
+		t.Fatal(got, md)
+	}
+}
+
+func TestOutgoingContextCopy(t *testing.T) {
+	md := brpc.Metadata{"A": "1"}
+	ctx := brpc.NewOutgoingContext(context.Background(), md)
+	md.Set("b", "2")
+	ctx2 := brpc.AppendToOutgoingContext(ctx, "C", "3")
+
+	if out := brpc.OutgoingMetadata(ctx); len(out) != 1 || out.Get("a") != "1" {
+		t.Fatal(out)
+	}
+	if out := brpc.OutgoingMetadata(ctx2); len(out) != 2 || out["c"] != "3" {
+		t.Fatal(out)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	var got brpc.TraceContext
+	ts := newTestServer(t, brpc.WithInterceptors(brpc.TraceInterceptor, func(ctx context.Context, call *brpc.Call, next brpc.Handler) error {
+		got, _ = brpc.TraceFromContext(ctx)
+		return next(ctx, call)
+	}))
+
+	parent := brpc.NewTraceContext(true)
+	clients := map[string]brpc.RpcClient{
+		"rw":   ts.client(t, rw.WithInterceptors(brpc.TraceClientInterceptor)),
+		"http": ts.httpClient(t, bhttp.WithInterceptors(brpc.TraceClientInterceptor)),
+	}
+	for name, c := range clients {
+		var out Resp
+		if err := c.CallContext(brpc.ContextWithTrace(context.Background(), parent), "service.Query", Req{Name: "admin"}, &out); err != nil {
+			t.Fatal(name, err)
+		}
+		// 同一个trace id，新的span id
+		if got.String()[:35] != parent.String()[:35] || got.String() == parent.String() {
+			t.Fatal(name, got, parent)
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tc := brpc.NewTraceContext(true)
+	parsed, err := brpc.ParseTraceparent(tc.String())
+	if err != nil || parsed != tc {
+		t.Fatal(err, parsed)
+	}
+
+	for _, s := range []string{
+		"",
+		"00-00000000000000000000000000000000-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"zz-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	} {
+		if _, err := brpc.ParseTraceparent(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package brpc
+
+import "context"
+
+// Invoke 用具体的请求和响应类型调用方法
+//
+//	user, err := brpc.Invoke[GetUserRequest, User](ctx, client, "user.Get", &GetUserRequest{ID: 1})
+func Invoke[Req, Resp any](ctx context.Context, client RpcClient, serviceName string, req *Req) (*Resp, error) {
+	resp := new(Resp)
+	if err := client.CallContext(ctx, serviceName, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Method 描述一个方法的名字和请求、响应类型，服务端注册和客户端调用共用同一个定义，
+// 名字和类型只需要声明一次
+//
+//	var GetUser = brpc.NewMethod[GetUserRequest, User]("user.Get")
+//
+//	// 服务端
+//	GetUser.Handle(s, func(ctx context.Context, req *GetUserRequest) (*User, error) { ... })
+//
+//	// 客户端
+//	user, err := GetUser.Invoke(ctx, client, &GetUserRequest{ID: 1})
+type Method[Req, Resp any] struct {
+	name string
+}
+
+func NewMethod[Req, Resp any](name string) Method[Req, Resp] {
+	return Method[Req, Resp]{name: name}
+}
+
+func (m Method[Req, Resp]) Name() string {
+	return m.name
+}
+
+func (m Method[Req, Resp]) Handle(s *Server, fn func(ctx context.Context, req *Req) (*Resp, error)) {
+	HandleFunc(s, m.name, fn)
+}
+
+func (m Method[Req, Resp]) Invoke(ctx context.Context, client RpcClient, req *Req) (*Resp, error) {
+	return Invoke[Req, Resp](ctx, client, m.name, req)
+}
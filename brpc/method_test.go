@@ -0,0 +1,37 @@
+package brpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+var Add = brpc.NewMethod[[2]int, int]("m.Add")
+
+func TestMethod(t *testing.T) {
+	ts := newTestServer(t)
+	Add.Handle(ts.Server, func(ctx context.Context, req *[2]int) (*int, error) {
+		r := req[0] + req[1]
+		return &r, nil
+	})
+	if Add.Name() != "m.Add" {
+		t.Fatal(Add.Name())
+	}
+
+	clients := map[string]brpc.RpcClient{"rw": ts.client(t), "http": ts.httpClient(t)}
+	for name, c := range clients {
+		r, err := Add.Invoke(context.Background(), c, &[2]int{2, 3})
+		if err != nil || *r != 5 {
+			t.Fatal(name, err)
+		}
+
+		q, err := brpc.Invoke[Req, Resp](context.Background(), c, "service.Query", &Req{Name: "admin"})
+		if err != nil || q.Age != 100 {
+			t.Fatal(name, err)
+		}
+		if _, err := brpc.Invoke[Req, Resp](context.Background(), c, "service.Query", &Req{Name: "x"}); err == nil {
+			t.Fatal(name, "expected error")
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package brpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Metrics 接收服务端和客户端的统计数据，需要是并发安全的，
+// brpc/metrics中有Prometheus文本格式的实现
+type Metrics interface {
+	// ObserveCall 记录一次调用，side是"server"或"client"，outcome见Outcome
+	ObserveCall(side string, method string, outcome string, duration time.Duration)
+	// IncRejected 记录服务端在处理之前拒绝的请求，例如"unknown-client"、"expired"
+	IncRejected(reason string)
+}
+
+// WithMetrics 设置服务端的统计
+func WithMetrics(metrics Metrics) serverOption {
+	return func(s *Server) {
+		s.metrics = metrics
+	}
+}
+
+// SetMetrics 设置客户端的统计，通过Invoke的每次调用都会被记录
+func (c *Client) SetMetrics(metrics Metrics) {
+	c.metrics = metrics
+}
+
+// Outcome 把调用结果归类成统计用的字符串: 成功是"ok"，带错误码的错误是错误码的名字，
+// 被拒绝的请求是拒绝原因，其他错误是"error"
+func Outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code.String()
+	}
+
+	switch {
+	case errors.Is(err, ErrPermissionDenied):
+		return CodePermissionDenied.String()
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded.String()
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrRequestRejected):
+		return "rejected"
+	}
+
+	for reason, e := range rejectReasons {
+		if errors.Is(err, e) {
+			return strings.ReplaceAll(reason, "-", "_")
+		}
+	}
+	return "error"
+}
+
+// 没有注册的方法统一记录成这个名字，避免客户端用随意的方法名制造无限多的统计项
+const unknownMethod = "unknown"
+
+// observeCall 记录一次调用，registered为false时方法名记录成unknownMethod
+func (s *Server) observeCall(method string, registered bool, err error, start time.Time) {
+	if s.metrics != nil {
+		if !registered {
+			method = unknownMethod
+		}
+		s.metrics.ObserveCall("server", method, Outcome(err), time.Since(start))
+	}
+}
+
+// rejected 记录被拒绝的请求并返回拒绝原因
+func (s *Server) rejected(err error) string {
+	reason := rejectReason(err)
+	if s.metrics != nil {
+		s.metrics.IncRejected(reason)
+	}
+	return reason
+}
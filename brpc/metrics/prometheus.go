@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Prometheus 在内存中记录brpc的统计数据，同时是输出Prometheus文本格式的http.Handler
+//
+//	m := metrics.NewPrometheus()
+//	s := brpc.NewServer(brpc.WithMetrics(m))
+//	http.Handle("/metrics", m)
+type Prometheus struct {
+	buckets []float64
+
+	lock     sync.Mutex
+	calls    map[callKey]*histogram
+	rejected map[string]uint64
+}
+
+var _ brpc.Metrics = (*Prometheus)(nil)
+
+type callKey struct {
+	side    string
+	method  string
+	outcome string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheus 创建统计，buckets是延迟直方图的上界，单位秒，为空时使用DefaultBuckets
+func NewPrometheus(buckets ...float64) *Prometheus {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+
+	return &Prometheus{
+		buckets:  buckets,
+		calls:    make(map[callKey]*histogram),
+		rejected: make(map[string]uint64),
+	}
+}
+
+func (p *Prometheus) ObserveCall(side string, method string, outcome string, duration time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := callKey{side: side, method: method, outcome: outcome}
+	h := p.calls[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.calls[key] = h
+	}
+
+	seconds := duration.Seconds()
+	if i := sort.SearchFloat64s(p.buckets, seconds); i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (p *Prometheus) IncRejected(reason string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.rejected[reason]++
+}
+
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	p.Write(bw)
+	bw.Flush()
+}
+
+// Write 按Prometheus文本格式输出所有统计数据
+func (p *Prometheus) Write(w io.Writer) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	keys := make([]callKey, 0, len(p.calls))
+	for key := range p.calls {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.side != b.side {
+			return a.side < b.side
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.outcome < b.outcome
+	})
+
+	fmt.Fprintln(w, "# HELP brpc_calls_total Total number of brpc calls.")
+	fmt.Fprintln(w, "# TYPE brpc_calls_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "brpc_calls_total{%v} %v\n", key.labels(), p.calls[key].count)
+	}
+
+	fmt.Fprintln(w, "# HELP brpc_call_duration_seconds Latency of brpc calls.")
+	fmt.Fprintln(w, "# TYPE brpc_call_duration_seconds histogram")
+	for _, key := range keys {
+		h, labels := p.calls[key], key.labels()
+		var cumulative uint64
+		for i, bound := range p.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "brpc_call_duration_seconds_bucket{%v,le=\"%v\"} %v\n", labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "brpc_call_duration_seconds_bucket{%v,le=\"+Inf\"} %v\n", labels, h.count)
+		fmt.Fprintf(w, "brpc_call_duration_seconds_sum{%v} %v\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "brpc_call_duration_seconds_count{%v} %v\n", labels, h.count)
+	}
+
+	reasons := make([]string, 0, len(p.rejected))
+	for reason := range p.rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	fmt.Fprintln(w, "# HELP brpc_rejected_total Requests rejected before being handled, e.g. unknown client keys or expired timestamps.")
+	fmt.Fprintln(w, "# TYPE brpc_rejected_total counter")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "brpc_rejected_total{reason=\"%v\"} %v\n", escape(reason), p.rejected[reason])
+	}
+}
+
+func (k callKey) labels() string {
+	return fmt.Sprintf(`side="%v",method="%v",outcome="%v"`, escape(k.side), escape(k.method), escape(k.outcome))
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
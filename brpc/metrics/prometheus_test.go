@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusWrite(t *testing.T) {
+	p := NewPrometheus(1, 0.1)
+	p.ObserveCall("server", "user.Get", "ok", 50*time.Millisecond)
+	p.ObserveCall("server", "user.Get", "ok", 500*time.Millisecond)
+	p.ObserveCall("server", "user.Get", "ok", 5*time.Second)
+	p.IncRejected("expired")
+
+	buf := new(bytes.Buffer)
+	p.Write(buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		`brpc_calls_total{side="server",method="user.Get",outcome="ok"} 3`,
+		`brpc_call_duration_seconds_bucket{side="server",method="user.Get",outcome="ok",le="0.1"} 1`,
+		`brpc_call_duration_seconds_bucket{side="server",method="user.Get",outcome="ok",le="1"} 2`,
+		`brpc_call_duration_seconds_bucket{side="server",method="user.Get",outcome="ok",le="+Inf"} 3`,
+		`brpc_call_duration_seconds_count{side="server",method="user.Get",outcome="ok"} 3`,
+		`brpc_rejected_total{reason="expired"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %v in\n%v", line, out)
+		}
+	}
+}
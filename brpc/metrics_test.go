@@ -0,0 +1,96 @@
+package brpc_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+	bhttp "github.com/abxuz/b-tools/v2/brpc/http"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+// recorder 记录收到的统计数据，key是"side method outcome"
+type recorder struct {
+	lock     sync.Mutex
+	calls    map[string]int
+	rejected map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{calls: make(map[string]int), rejected: make(map[string]int)}
+}
+
+func (r *recorder) ObserveCall(side string, method string, outcome string, duration time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls[side+" "+method+" "+outcome]++
+}
+
+func (r *recorder) IncRejected(reason string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rejected[reason]++
+}
+
+func (r *recorder) count(key string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.calls[key]
+}
+
+func TestMetrics(t *testing.T) {
+	m := newRecorder()
+	ts := newTestServer(t, brpc.WithMetrics(m))
+	c := ts.client(t, rw.WithMetrics(m), rw.WithMux())
+
+	var r Resp
+	c.Call("service.Query", Req{Name: "admin"}, &r)
+	c.Call("service.Query", Req{Name: "x"}, &r)
+	c.Call("service.Nope", Req{Name: "x"}, &r)
+	c.Call("other.Nope", Req{Name: "x"}, &r)
+
+	stranger, _ := bcrypt.NewPrivateKey()
+	hc := ts.httpClient(t, bhttp.WithClientPrivateKey(stranger), bhttp.WithMetrics(m))
+	hc.Call("service.Query", Req{Name: "admin"}, &r)
+
+	want := map[string]int{
+		"server service.Query ok":           1,
+		"server service.Query error":        1,
+		"client service.Query ok":           1,
+		"client service.Query error":        1,
+		"client service.Nope unimplemented": 1,
+		// 没有注册的方法不按客户端发来的名字区分，避免标签数量无限增长
+		"server unknown unimplemented":        2,
+		"server service.Nope unimplemented":   0,
+		"client service.Query unknown_client": 1,
+	}
+	for key, n := range want {
+		if got := m.count(key); got != n {
+			t.Errorf("%v: got %v, want %v", key, got, n)
+		}
+	}
+	if m.rejected["unknown-client"] != 1 {
+		t.Error("rejected", m.rejected)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	cases := map[error]string{
+		nil:                        "ok",
+		errors.New("x"):            "error",
+		brpc.ErrPermissionDenied:   "permission_denied",
+		context.DeadlineExceeded:   "deadline_exceeded",
+		context.Canceled:           "canceled",
+		brpc.ErrUnknownClient:      "unknown_client",
+		brpc.ErrConcurrencyLimited: "resource_exhausted",
+	}
+	for err, want := range cases {
+		if got := brpc.Outcome(err); got != want {
+			t.Errorf("%v: got %v, want %v", err, got, want)
+		}
+	}
+}
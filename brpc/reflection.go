@@ -0,0 +1,160 @@
+package brpc
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ReflectionDescribe 返回服务端注册的所有方法和请求响应的类型，需要用WithReflection开启。
+// 没有设置ACL时所有客户端都不能调用，设置了ACL时只有被允许调用这个方法的客户端可以调用。
+var ReflectionDescribe = NewMethod[struct{}, ServerDescription]("brpc.Reflection.Describe")
+
+type ServerDescription struct {
+	Services []ServiceDescription `msgpack:"services" json:"services"`
+	// 所有用到的结构体类型，key是TypeDescription.Name
+	Types map[string]*TypeDescription `msgpack:"types" json:"types"`
+}
+
+type ServiceDescription struct {
+	Name    string              `msgpack:"name" json:"name"`
+	Methods []MethodDescription `msgpack:"methods" json:"methods"`
+}
+
+// MethodDescription 描述一个方法，流式方法和用Handle注册的方法没有类型信息
+type MethodDescription struct {
+	Name     string           `msgpack:"name" json:"name"`
+	Stream   bool             `msgpack:"stream,omitempty" json:"stream,omitempty"`
+	Request  *TypeDescription `msgpack:"request,omitempty" json:"request,omitempty"`
+	Response *TypeDescription `msgpack:"response,omitempty" json:"response,omitempty"`
+}
+
+// TypeDescription 描述Go类型，结构体只在ServerDescription.Types里展开，其他地方用Ref引用
+type TypeDescription struct {
+	// reflect.Kind的名字，例如"struct"、"slice"、"int64"
+	Kind string `msgpack:"kind" json:"kind"`
+	// 带包路径的类型名，没有名字的类型为空
+	Name   string             `msgpack:"name,omitempty" json:"name,omitempty"`
+	Ref    string             `msgpack:"ref,omitempty" json:"ref,omitempty"`
+	Key    *TypeDescription   `msgpack:"key,omitempty" json:"key,omitempty"`
+	Elem   *TypeDescription   `msgpack:"elem,omitempty" json:"elem,omitempty"`
+	Len    int                `msgpack:"len,omitempty" json:"len,omitempty"`
+	Fields []FieldDescription `msgpack:"fields,omitempty" json:"fields,omitempty"`
+}
+
+type FieldDescription struct {
+	Name     string           `msgpack:"name" json:"name"`
+	Tag      string           `msgpack:"tag,omitempty" json:"tag,omitempty"`
+	Embedded bool             `msgpack:"embedded,omitempty" json:"embedded,omitempty"`
+	Type     *TypeDescription `msgpack:"type" json:"type"`
+}
+
+var typeOfCaller = reflect.TypeFor[Caller]()
+
+// WithReflection 开启内置的反射服务，见ReflectionDescribe
+func WithReflection() serverOption {
+	return func(s *Server) {
+		ReflectionDescribe.Handle(s, func(ctx context.Context, req *struct{}) (*ServerDescription, error) {
+			// 没有ACL时authorize不会拦截任何调用
+			if s.acl == nil {
+				return nil, ErrPermissionDenied
+			}
+			return s.describe(), nil
+		})
+	}
+}
+
+func (s *Server) describe() *ServerDescription {
+	d := &ServerDescription{Types: make(map[string]*TypeDescription)}
+	services := make(map[string]*ServiceDescription)
+	add := func(name string, md MethodDescription) {
+		i := strings.LastIndexByte(name, '.')
+		md.Name = name[i+1:]
+		service := services[name[:max(i, 0)]]
+		if service == nil {
+			service = &ServiceDescription{Name: name[:max(i, 0)]}
+			services[service.Name] = service
+		}
+		service.Methods = append(service.Methods, md)
+	}
+
+	s.methodsLock.RLock()
+	for name, m := range s.methods {
+		add(name, MethodDescription{
+			Request:  describeType(m.reqType, d.Types),
+			Response: describeType(m.respType, d.Types),
+		})
+	}
+	s.methodsLock.RUnlock()
+
+	s.streamHandlersLock.RLock()
+	for name := range s.streamHandlers {
+		add(name, MethodDescription{Stream: true})
+	}
+	s.streamHandlersLock.RUnlock()
+
+	for _, service := range services {
+		sort.Slice(service.Methods, func(i, j int) bool { return service.Methods[i].Name < service.Methods[j].Name })
+		d.Services = append(d.Services, *service)
+	}
+	sort.Slice(d.Services, func(i, j int) bool { return d.Services[i].Name < d.Services[j].Name })
+	return d
+}
+
+func typeName(t reflect.Type) string {
+	if t.Name() == "" {
+		return ""
+	}
+	if t.PkgPath() == "" {
+		return t.Name()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+func describeType(t reflect.Type, types map[string]*TypeDescription) *TypeDescription {
+	if t == nil {
+		return nil
+	}
+
+	d := &TypeDescription{Kind: t.Kind().String(), Name: typeName(t)}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice:
+		d.Elem = describeType(t.Elem(), types)
+	case reflect.Array:
+		d.Elem = describeType(t.Elem(), types)
+		d.Len = t.Len()
+	case reflect.Map:
+		d.Key = describeType(t.Key(), types)
+		d.Elem = describeType(t.Elem(), types)
+	case reflect.Struct:
+		if d.Name == "" {
+			d.Fields = describeFields(t, types)
+			return d
+		}
+		if _, ok := types[d.Name]; !ok {
+			// 先占位，递归引用自己的类型不会无限展开
+			types[d.Name] = d
+			d.Fields = describeFields(t, types)
+		}
+		return &TypeDescription{Kind: d.Kind, Ref: d.Name}
+	}
+	return d
+}
+
+func describeFields(t reflect.Type, types map[string]*TypeDescription) []FieldDescription {
+	var fields []FieldDescription
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Type == typeOfCaller {
+			continue
+		}
+		fields = append(fields, FieldDescription{
+			Name:     f.Name,
+			Tag:      string(f.Tag),
+			Embedded: f.Anonymous,
+			Type:     describeType(f.Type, types),
+		})
+	}
+	return fields
+}
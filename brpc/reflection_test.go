@@ -0,0 +1,61 @@
+package brpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+type Node struct {
+	Val      int `msgpack:"v"`
+	Children []*Node
+}
+
+func TestReflection(t *testing.T) {
+	ts := newTestServer(t, brpc.WithReflection())
+	brpc.HandleFunc(ts.Server, "tree.Get", func(ctx context.Context, req *map[string]Node) (*[]Node, error) {
+		return nil, nil
+	})
+	ts.HandleStream("log.Tail", func(st *brpc.ServerStream) error { return nil })
+	c := ts.client(t)
+
+	// 没有ACL时不允许调用
+	if _, err := brpc.ReflectionDescribe.Invoke(context.Background(), c, &struct{}{}); err != brpc.ErrPermissionDenied {
+		t.Fatal(err)
+	}
+
+	acl := brpc.NewACL()
+	acl.AllowClient(ts.clientKey.PublicKey(), "brpc.Reflection.*")
+	ts.SetACL(acl)
+	d, err := brpc.ReflectionDescribe.Invoke(context.Background(), c, &struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	methods := make(map[string]brpc.MethodDescription)
+	for _, s := range d.Services {
+		for _, m := range s.Methods {
+			methods[s.Name+"."+m.Name] = m
+		}
+	}
+	if m, ok := methods["log.Tail"]; !ok || !m.Stream {
+		t.Fatal("log.Tail", m)
+	}
+	m, ok := methods["tree.Get"]
+	if !ok || m.Request.Kind != "map" || m.Request.Elem.Ref == "" || m.Response.Kind != "slice" {
+		t.Fatalf("tree.Get %+v", m)
+	}
+	if _, ok := methods["service.Query"]; !ok {
+		t.Fatal("service.Query missing")
+	}
+
+	// 递归的结构体只展开一次
+	node := d.Types[m.Request.Elem.Ref]
+	if node == nil || len(node.Fields) != 2 || node.Fields[0].Tag != `msgpack:"v"` {
+		t.Fatalf("%+v", node)
+	}
+	if child := node.Fields[1].Type; child.Kind != "slice" || child.Elem.Kind != "ptr" || child.Elem.Elem.Ref != m.Request.Elem.Ref {
+		t.Fatalf("%+v", child)
+	}
+}
@@ -0,0 +1,213 @@
+package brpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"reflect"
+)
+
+// MethodFunc 是注册到服务端的方法的通用形式，req是解码后的请求，resp会被编码后发给客户端
+type MethodFunc = func(ctx context.Context, req any) (resp any, err error)
+
+type method struct {
+	name string
+	// 请求和响应的类型，不知道时为nil
+	reqType  reflect.Type
+	respType reflect.Type
+
+	newRequest func() any
+	fn         MethodFunc
+}
+
+var typeOfError = reflect.TypeFor[error]()
+
+// Handle 注册方法，name是"Service.Method"形式的方法名，
+// newRequest返回解码请求用的指针，同名的方法会被替换
+func (s *Server) Handle(name string, newRequest func() any, fn MethodFunc) {
+	var reqType reflect.Type
+	if t := reflect.TypeOf(newRequest()); t != nil && t.Kind() == reflect.Pointer {
+		reqType = t.Elem()
+	}
+	s.addMethod(&method{name: name, reqType: reqType, newRequest: newRequest, fn: fn})
+}
+
+// HandleFunc 用具体的请求和响应类型注册方法，不需要在每次调用时反射
+//
+//	brpc.HandleFunc(s, "user.Get", func(ctx context.Context, req *GetUserRequest) (*User, error) {
+//		...
+//	})
+func HandleFunc[Req, Resp any](s *Server, name string, fn func(ctx context.Context, req *Req) (*Resp, error)) {
+	s.addMethod(&method{
+		name:       name,
+		reqType:    reflect.TypeFor[Req](),
+		respType:   reflect.TypeFor[Resp](),
+		newRequest: func() any { return new(Req) },
+		fn: func(ctx context.Context, req any) (any, error) {
+			return fn(ctx, req.(*Req))
+		},
+	})
+}
+
+func (s *Server) addMethod(m *method) {
+	s.methodsLock.Lock()
+	defer s.methodsLock.Unlock()
+	s.methods[m.name] = m
+}
+
+func (s *Server) getMethod(name string) *method {
+	s.methodsLock.RLock()
+	defer s.methodsLock.RUnlock()
+	return s.methods[name]
+}
+
+// Register 和net/rpc一样，把rcvr中所有符合
+//
+//	func (t *T) MethodName(req T1, resp *T2) error
+//
+// 形式的导出方法注册为"T.MethodName"，T1和T2需要是导出的或者内置的类型
+func (s *Server) Register(rcvr any) error {
+	return s.register(rcvr, "", false)
+}
+
+// RegisterName 和Register一样，但是用name代替rcvr的类型名
+func (s *Server) RegisterName(name string, rcvr any) error {
+	return s.register(rcvr, name, true)
+}
+
+func (s *Server) register(rcvr any, name string, useName bool) error {
+	rcvrType := reflect.TypeOf(rcvr)
+	rcvrValue := reflect.ValueOf(rcvr)
+	sname := name
+	if !useName {
+		sname = reflect.Indirect(rcvrValue).Type().Name()
+	}
+	if sname == "" {
+		return fmt.Errorf("brpc.Register: no service name for type %v", rcvrType)
+	}
+	if !useName && !token.IsExported(sname) {
+		return fmt.Errorf("brpc.Register: type %v is not exported", sname)
+	}
+
+	methods := suitableMethods(rcvrType)
+	if len(methods) == 0 {
+		return fmt.Errorf("brpc.Register: type %v has no exported methods of suitable type", sname)
+	}
+
+	s.methodsLock.Lock()
+	defer s.methodsLock.Unlock()
+
+	if s.services[sname] {
+		return errors.New("brpc: service already defined: " + sname)
+	}
+	s.services[sname] = true
+
+	for _, m := range methods {
+		m := newReflectMethod(sname+"."+m.Name, rcvrValue, m)
+		s.methods[m.name] = m
+	}
+	return nil
+}
+
+// suitableMethods 返回符合Register要求的方法
+func suitableMethods(typ reflect.Type) []reflect.Method {
+	var methods []reflect.Method
+	for i := 0; i < typ.NumMethod(); i++ {
+		m := typ.Method(i)
+		if !m.IsExported() {
+			continue
+		}
+
+		mtype := m.Type
+		if mtype.NumIn() != 3 || mtype.NumOut() != 1 {
+			continue
+		}
+		if !isExportedOrBuiltinType(mtype.In(1)) {
+			continue
+		}
+		replyType := mtype.In(2)
+		if replyType.Kind() != reflect.Pointer || !isExportedOrBuiltinType(replyType) {
+			continue
+		}
+		if mtype.Out(0) != typeOfError {
+			continue
+		}
+		methods = append(methods, m)
+	}
+	return methods
+}
+
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+func newReflectMethod(name string, rcvr reflect.Value, m reflect.Method) *method {
+	argType := m.Type.In(1)
+	argIsValue := argType.Kind() != reflect.Pointer
+	if !argIsValue {
+		argType = argType.Elem()
+	}
+	replyType := m.Type.In(2).Elem()
+
+	return &method{
+		name:     name,
+		reqType:  argType,
+		respType: replyType,
+		newRequest: func() any {
+			return reflect.New(argType).Interface()
+		},
+		fn: func(ctx context.Context, req any) (any, error) {
+			argv := reflect.ValueOf(req)
+			if argIsValue {
+				argv = argv.Elem()
+			}
+
+			// 和net/rpc一样，map和slice类型的响应先初始化
+			replyv := reflect.New(replyType)
+			switch replyType.Kind() {
+			case reflect.Map:
+				replyv.Elem().Set(reflect.MakeMap(replyType))
+			case reflect.Slice:
+				replyv.Elem().Set(reflect.MakeSlice(replyType, 0, 0))
+			}
+
+			out := m.Func.Call([]reflect.Value{rcvr, argv, replyv})
+			if err := out[0].Interface(); err != nil {
+				return nil, err.(error)
+			}
+			return replyv.Interface(), nil
+		},
+	}
+}
+
+// decodeRequest 找到方法并解码请求
+func (s *Server) decodeRequest(name string, codec Codec, body []byte) (*method, any, error) {
+	m := s.getMethod(name)
+	if m == nil {
+		return nil, nil, Errorf(CodeUnimplemented, "brpc: can't find method %v", name)
+	}
+
+	req := m.newRequest()
+	if err := codec.Unmarshal(body, req); err != nil {
+		return nil, nil, Errorf(CodeInvalidArgument, "brpc: invalid request: %v", err)
+	}
+	return m, req, nil
+}
+
+// serveMethod 用解码后的call.Request调用方法，结果记录在call里
+func (s *Server) serveMethod(ctx context.Context, call *Call, m *method) error {
+	if setter, ok := call.Request.(callerSetter); ok {
+		setter.setCallerContext(ctx)
+	}
+
+	resp, err := m.fn(ctx, call.Request)
+	if err != nil {
+		return err
+	}
+	call.Response = resp
+	return nil
+}
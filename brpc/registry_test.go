@@ -0,0 +1,105 @@
+package brpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+type NF struct{}
+
+func (NF) Get(req int, resp *[]int) error {
+	if req < 0 {
+		return brpc.NewError(brpc.CodeNotFound, "neg")
+	}
+	*resp = append(*resp, req)
+	return nil
+}
+
+type unexported struct{}
+
+func (unexported) Get(req int, resp *int) error { return nil }
+
+func TestRegister(t *testing.T) {
+	ts := newTestServer(t)
+	if err := ts.RegisterName("service", &Service{}); err == nil {
+		t.Fatal("duplicate service registered")
+	}
+	if err := ts.Register(unexported{}); err == nil {
+		t.Fatal("unexported type registered")
+	}
+	if err := ts.Register(NF{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := ts.client(t, rw.WithMux())
+	var out []int
+	if err := c.Call("NF.Get", 5, &out); err != nil || len(out) != 1 || out[0] != 5 {
+		t.Fatal(err, out)
+	}
+	if err := c.Call("NF.Get", -1, &out); brpc.ErrorCodeOf(err) != brpc.CodeNotFound {
+		t.Fatal(err)
+	}
+	if err := c.Call("NF.Nope", 1, &out); brpc.ErrorCodeOf(err) != brpc.CodeUnimplemented {
+		t.Fatal(err)
+	}
+	if err := c.Call("NF.Get", "bad", &out); brpc.ErrorCodeOf(err) != brpc.CodeInvalidArgument {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleFunc(t *testing.T) {
+	ts := newTestServer(t)
+	brpc.HandleFunc(ts.Server, "fn.Add", func(ctx context.Context, req *[2]int) (*int, error) {
+		if _, ok := brpc.ClientFromContext(ctx); !ok {
+			return nil, errors.New("no client")
+		}
+		r := req[0] + req[1]
+		return &r, nil
+	})
+	ts.Handle("raw.X", func() any { return new(string) }, func(ctx context.Context, req any) (any, error) {
+		return *req.(*string) + "!", nil
+	})
+
+	c := ts.client(t)
+	var sum int
+	if err := c.Call("fn.Add", [2]int{1, 2}, &sum); err != nil || sum != 3 {
+		t.Fatal(err, sum)
+	}
+	if err := c.Call("fn.Add", "bad", &sum); brpc.ErrorCodeOf(err) != brpc.CodeInvalidArgument {
+		t.Fatal(err)
+	}
+	var x string
+	if err := c.Call("raw.X", "hi", &x); err != nil || x != "hi!" {
+		t.Fatal(err, x)
+	}
+}
+
+func TestHandlerContextCancel(t *testing.T) {
+	ts := newTestServer(t)
+	started := make(chan struct{})
+	done := make(chan struct{})
+	brpc.HandleFunc(ts.Server, "wait.Done", func(ctx context.Context, req *int) (*int, error) {
+		close(started)
+		<-ctx.Done()
+		close(done)
+		return req, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		var out int
+		errc <- ts.client(t).CallContext(ctx, "wait.Done", 1, &out)
+	}()
+	// 调用单独使用的连接在取消后关闭，服务端随之结束处理函数的ctx
+	<-started
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatal(err)
+	}
+	<-done
+}
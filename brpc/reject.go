@@ -0,0 +1,72 @@
+package brpc
+
+import (
+	"errors"
+	"net/http"
+)
+
+// 服务端在验证身份之前拒绝请求时，只能把原因以明文发给客户端，
+// 客户端通过RejectionError转换成下面的错误，方便排查密钥和时间配置的问题
+var (
+	ErrUnknownClient  = errors.New("unknown client public key")
+	ErrRequestExpired = errors.New("request expired, sync time with server")
+	ErrDecryptFailed  = errors.New("decrypt failed, check the server public key")
+	ErrServerInternal = errors.New("server internal error")
+)
+
+// internalError 是服务端自己的错误，和客户端的请求无关
+type internalError struct {
+	err error
+}
+
+func (e internalError) Error() string { return e.err.Error() }
+
+func (e internalError) Unwrap() error { return e.err }
+
+var rejectReasons = map[string]error{
+	"unknown-client": ErrUnknownClient,
+	"expired":        ErrRequestExpired,
+	"decrypt":        ErrDecryptFailed,
+	"internal":       ErrServerInternal,
+	"too-large":      ErrMessageTooLarge,
+	"closed":         ErrServerClosed,
+	"replay-full":    ErrReplayCacheFull,
+	"streams-full":   ErrTooManyStreams,
+	"stream-in-use":  ErrStreamIDInUse,
+}
+
+// rejectReason 返回拒绝请求的原因，没有对应原因的错误统一为"rejected"
+func rejectReason(err error) string {
+	var internal internalError
+	if errors.As(err, &internal) {
+		return "internal"
+	}
+
+	for reason, e := range rejectReasons {
+		if errors.Is(err, e) {
+			return reason
+		}
+	}
+	return "rejected"
+}
+
+func rejectStatusCode(reason string) int {
+	switch reason {
+	case "internal":
+		return http.StatusInternalServerError
+	case "too-large":
+		return http.StatusRequestEntityTooLarge
+	case "closed", "replay-full", "streams-full":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// RejectionError 把服务端发来的拒绝原因转换成对应的错误，未知的原因返回ErrRequestRejected
+func RejectionError(reason string) error {
+	if err, ok := rejectReasons[reason]; ok {
+		return err
+	}
+	return ErrRequestRejected
+}
@@ -0,0 +1,132 @@
+package brpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+	bhttp "github.com/abxuz/b-tools/v2/brpc/http"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestReject(t *testing.T) {
+	ts := newTestServer(t)
+	stranger, _ := bcrypt.NewPrivateKey()
+	wrong, _ := bcrypt.NewPrivateKey()
+	hs := httptest.NewServer(ts.Server)
+	defer hs.Close()
+
+	cases := []struct {
+		name      string
+		clientKey bcrypt.NoisePrivateKey
+		serverKey bcrypt.NoisePublicKey
+		want      error
+	}{
+		{"unknown client", stranger, ts.serverKey, brpc.ErrUnknownClient},
+		{"wrong server key", ts.clientKey, wrong.PublicKey(), brpc.ErrDecryptFailed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, mode := range clientModes {
+				opts := append([]func(*rw.Client){rw.WithServerPublicKey(c.serverKey)}, mode.opts...)
+				client := ts.clientWithKey(t, c.clientKey, opts...)
+				var r Resp
+				if err := client.Call("service.Query", Req{Name: "admin"}, &r); err != c.want {
+					t.Fatal(mode.name, err)
+				}
+			}
+
+			st, err := ts.clientWithKey(t, c.clientKey, rw.WithServerPublicKey(c.serverKey), rw.WithMux()).
+				NewStream(context.Background(), "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			if err := st.Recv(nil); err != c.want {
+				t.Fatal("stream", err)
+			}
+
+			hc := bhttp.NewClient(bhttp.WithClientPrivateKey(c.clientKey), bhttp.WithServerPublicKey(c.serverKey), bhttp.WithEndpoint(hs.URL))
+			var r Resp
+			if err := hc.Call("service.Query", Req{Name: "admin"}, &r); err != c.want {
+				t.Fatal("http", err)
+			}
+		})
+	}
+}
+
+func TestRejectLegacyTimestamp(t *testing.T) {
+	ts := newTestServer(t)
+	stranger, _ := bcrypt.NewPrivateKey()
+
+	var c brpc.Client
+	c.SetClientPrivateKey(stranger)
+	c.SetServerPublicKey(ts.serverKey)
+	var (
+		ePubKey   bcrypt.NoisePublicKey
+		timestamp int64
+	)
+	data, err := c.WriteRequestMessage(nil, "service.Query", Req{Name: "admin"}, &ePubKey, &timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ts.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := new(bytes.Buffer)
+	buf.Write(ePubKey[:])
+	binary.Write(buf, binary.BigEndian, timestamp)
+	binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+	conn.Write(buf.Bytes())
+
+	// 老客户端不认识全0的临时公钥，拒绝响应的时间也要在有效期内，不能让它误报响应过期
+	var head [42]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	rt := int64(binary.BigEndian.Uint64(head[32:40]))
+	if [32]byte(head[:32]) != [32]byte{} || time.Now().Unix()-rt > 60 {
+		t.Fatal(head)
+	}
+}
+
+func TestRejectExpiredHTTP(t *testing.T) {
+	ts := newTestServer(t)
+	hs := httptest.NewServer(ts.Server)
+	defer hs.Close()
+
+	req, _ := http.NewRequest("POST", hs.URL, nil)
+	req.Header.Set("X-Rpc-E", ts.serverKey.String())
+	req.Header.Set("X-Rpc-T", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get("X-Rpc-Error") != "expired" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+}
+
+func TestRejectionError(t *testing.T) {
+	if err := brpc.RejectionError("closed"); err != brpc.ErrServerClosed {
+		t.Fatal(err)
+	}
+	if err := brpc.RejectionError("replay-full"); err != brpc.ErrReplayCacheFull {
+		t.Fatal(err)
+	}
+	if err := brpc.RejectionError("something-new"); err != brpc.ErrRequestRejected {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,92 @@
+package brpc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+// DefaultReplayCacheSize 是每个客户端密钥的防重放记录的最大条数
+const DefaultReplayCacheSize = 1 << 20
+
+var errRequestReplayed = errors.New("request replayed")
+
+// ErrReplayCacheFull 表示客户端在时间窗口内发送的请求太多，防重放记录已满，稍后可以重试
+var ErrReplayCacheFull = errors.New("replay cache full, retry later")
+
+// replayCache 记录时间窗口内见过的临时公钥，客户端每条消息都会生成新的临时密钥，
+// 所以同一个临时公钥出现第二次就是重放。记录按客户端密钥分开，过期的记录定期清理，
+// 一个客户端的记录数达到上限后拒绝它新的请求，宁可拒绝服务也不放过重放，
+// 但不会影响其他客户端。
+type replayCache struct {
+	lock      sync.Mutex
+	clients   map[bcrypt.NoisePublicKey]*replayEntries
+	maxSize   int
+	nextSweep int64
+}
+
+type replayEntries struct {
+	entries   map[bcrypt.NoisePublicKey]int64
+	lastSweep int64
+}
+
+func newReplayCache(maxSize int) *replayCache {
+	return &replayCache{
+		clients: make(map[bcrypt.NoisePublicKey]*replayEntries),
+		maxSize: maxSize,
+	}
+}
+
+// add 记录client发来的一个临时公钥，expire之后这条记录可以被清理，
+// 调用方要保证expire之后带着这个公钥的消息会因为过期而被拒绝
+func (c *replayCache) add(client *bcrypt.NoisePublicKey, key *bcrypt.NoisePublicKey, expire int64, now int64, sweepInterval int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now >= c.nextSweep {
+		for pk, r := range c.clients {
+			r.sweep(now)
+			if len(r.entries) == 0 {
+				delete(c.clients, pk)
+			}
+		}
+		c.nextSweep = now + sweepInterval
+	}
+
+	r := c.clients[*client]
+	if r == nil {
+		r = &replayEntries{entries: make(map[bcrypt.NoisePublicKey]int64)}
+		c.clients[*client] = r
+	}
+
+	if _, ok := r.entries[*key]; ok {
+		return errRequestReplayed
+	}
+
+	// 满了的时候也清理一次，但每秒最多一次，避免每个请求都遍历整个表
+	if len(r.entries) >= c.maxSize && now > r.lastSweep {
+		r.sweep(now)
+	}
+	if len(r.entries) >= c.maxSize {
+		return ErrReplayCacheFull
+	}
+
+	r.entries[*key] = expire
+	return nil
+}
+
+func (r *replayEntries) sweep(now int64) {
+	for k, v := range r.entries {
+		if v < now {
+			delete(r.entries, k)
+		}
+	}
+	r.lastSweep = now
+}
+
+func (c *replayCache) setMaxSize(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxSize = n
+}
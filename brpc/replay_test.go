@@ -0,0 +1,110 @@
+package brpc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+// sendLegacy 用老协议把data原样发给服务端，返回服务端是否拒绝了请求
+func sendLegacy(t *testing.T, ts *testServer, ePubKey *bcrypt.NoisePublicKey, timestamp int64, data []byte) (rejected bool) {
+	t.Helper()
+
+	conn, err := ts.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := new(bytes.Buffer)
+	buf.Write(ePubKey[:])
+	binary.Write(buf, binary.BigEndian, timestamp)
+	binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 拒绝时临时公钥部分全是0
+	var head [42]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	return [32]byte(head[:32]) == [32]byte{}
+}
+
+func TestReplay(t *testing.T) {
+	ts := newTestServer(t)
+
+	var c brpc.Client
+	c.SetClientPrivateKey(ts.clientKey)
+	c.SetServerPublicKey(ts.serverKey)
+
+	var (
+		ePubKey   bcrypt.NoisePublicKey
+		timestamp int64
+	)
+	data, err := c.WriteRequestMessage(nil, "service.Query", Req{Name: "admin"}, &ePubKey, &timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sendLegacy(t, ts, &ePubKey, timestamp, data) {
+		t.Fatal("first request rejected")
+	}
+	if !sendLegacy(t, ts, &ePubKey, timestamp, data) {
+		t.Fatal("replayed request accepted")
+	}
+	if !sendLegacy(t, ts, &ePubKey, timestamp+1000, data) {
+		t.Fatal("request from the future accepted")
+	}
+}
+
+func TestReplayCacheFull(t *testing.T) {
+	ts := newTestServer(t)
+	ts.SetReplayCacheSize(2)
+	other, _ := bcrypt.NewPrivateKey()
+	ts.AddClientPublicKey(other.PublicKey())
+
+	c := ts.client(t)
+	var out Resp
+	for i := 0; i < 2; i++ {
+		if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != brpc.ErrReplayCacheFull {
+		t.Fatal(err)
+	}
+
+	// 记录按客户端分开，一个客户端满了不影响其他客户端
+	if err := ts.clientWithKey(t, other).Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayStreamMessages(t *testing.T) {
+	ts := newStreamServer(t)
+	ts.SetReplayCacheSize(2)
+
+	// 只有打开流的消息占用防重放记录，流上的消息不占用
+	c := ts.client(t, rw.WithMux())
+	st, err := c.NewStream(context.Background(), "up.Sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	for i := 1; i <= 10; i++ {
+		st.Send(i)
+	}
+	var sum int
+	if err := st.CloseAndRecv(&sum); err != nil || sum != 55 {
+		t.Fatal(err, sum)
+	}
+}
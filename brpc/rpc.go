@@ -9,6 +9,9 @@ import (
 	"github.com/abxuz/b-tools/v2/bcrypt"
 )
 
+// AES-GCM 附加的认证标签长度
+const aeadOverhead = 16
+
 func encrypt(privKey *bcrypt.NoisePrivateKey, pubKey *bcrypt.NoisePublicKey, t int64, data []byte) ([]byte, error) {
 	ss := privKey.SharedSecret(pubKey)
 	block, err := aes.NewCipher(ss[:])
@@ -6,6 +6,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abxuz/b-tools/v2/bcrypt"
@@ -14,27 +17,150 @@ import (
 
 type OpenFunc = func() (io.ReadWriteCloser, error)
 
+type OpenContextFunc = func(ctx context.Context) (io.ReadWriteCloser, error)
+
+// DefaultHandshakeTimeout 是默认等待服务端回复多路复用协议握手的时间
+const DefaultHandshakeTimeout = 5 * time.Second
+
+// ErrFramingUnsupported 表示服务端没有在握手超时时间内回复握手，通常是不支持多路复用协议的老版本服务端
+var ErrFramingUnsupported = errors.New("server does not support the framed protocol")
+
 type Client struct {
 	brpc.Client
-	open OpenFunc
+	open           OpenContextFunc
+	maxMessageSize int
+	// 流上最多缓存的未读消息数
+	streamRecvBuffer int
+	handshakeTimeout time.Duration
+
+	legacy bool
+	// 握手超时过的老版本服务端，之后的调用直接使用老协议
+	legacyServer atomic.Bool
+
+	mux     bool
+	muxConn *muxConn
+	muxDial *muxDial
+	muxLock sync.Mutex
+
+	pool *pool
 }
 
 type option = func(c *Client)
 
 func NewClient(opts ...option) *Client {
-	c := new(Client)
+	c := &Client{
+		maxMessageSize:   brpc.DefaultMaxMessageSize,
+		streamRecvBuffer: brpc.DefaultStreamRecvBuffer,
+		handshakeTimeout: DefaultHandshakeTimeout,
+	}
 	for _, opt := range opts {
 		opt(c)
 	}
 	return c
 }
 
+// WithOpen 设置打开连接的方法，open本身不能被取消，ctx结束时不再等待它，
+// 之后打开的连接会被直接关闭
 func WithOpen(open OpenFunc) option {
+	return func(c *Client) {
+		c.open = func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return openContext(ctx, open)
+		}
+	}
+}
+
+func WithOpenContext(open OpenContextFunc) option {
 	return func(c *Client) {
 		c.open = open
 	}
 }
 
+// WithMaxMessageSize 设置请求和响应加密后数据的最大长度，
+// WithLegacyProtocol模式受老协议限制，最大只能到64KiB
+func WithMaxMessageSize(n int) option {
+	return func(c *Client) {
+		c.maxMessageSize = n
+	}
+}
+
+// WithStreamRecvBuffer 设置每个流最多缓存的服务端消息数，Recv来不及处理、缓存满了时
+// 流以brpc.ErrStreamOverflow结束，不大于0时使用brpc.DefaultStreamRecvBuffer
+func WithStreamRecvBuffer(n int) option {
+	return func(c *Client) {
+		if n <= 0 {
+			n = brpc.DefaultStreamRecvBuffer
+		}
+		c.streamRecvBuffer = n
+	}
+}
+
+// WithHandshakeTimeout 设置等待服务端回复多路复用协议握手的时间，超时后调用返回ErrFramingUnsupported，
+// 不大于0时使用DefaultHandshakeTimeout
+func WithHandshakeTimeout(d time.Duration) option {
+	return func(c *Client) {
+		if d <= 0 {
+			d = DefaultHandshakeTimeout
+		}
+		c.handshakeTimeout = d
+	}
+}
+
+// WithLegacyProtocol 使用不支持多路复用的老协议，每个调用单独打开一个连接，
+// 请求和响应最大64KiB，只在连接不支持多路复用协议的老版本服务端时使用。
+// 默认每个调用也单独打开一个连接，但使用多路复用协议，没有64KiB的限制，
+// 握手超时时认为是老版本的服务端，这个调用和之后的调用都改用老协议
+func WithLegacyProtocol() option {
+	return func(c *Client) {
+		c.legacy = true
+	}
+}
+
+// WithMux 让所有调用复用同一个连接，需要服务端支持多路复用模式，和WithPool只能选一个，后设置的生效
+func WithMux() option {
+	return func(c *Client) {
+		c.mux = true
+		c.pool = nil
+	}
+}
+
+// WithPool 让调用复用连接池中的连接，需要服务端支持多路复用模式。和WithMux不同，
+// 每个连接同时只处理一个调用。maxIdle是最多保留的空闲连接数，为0时使用DefaultPoolMaxIdle，
+// maxOpen是最多同时打开的连接数，达到上限时调用会等待其他调用结束，为0时不限制，
+// 空闲超过idleTimeout的连接会被关闭，为0时不关闭。和WithMux只能选一个，后设置的生效，
+// Close之后调用会返回ErrPoolClosed
+func WithPool(maxIdle int, maxOpen int, idleTimeout time.Duration) option {
+	return func(c *Client) {
+		c.mux = false
+		c.pool = newPool(c.openMuxConn, maxIdle, maxOpen, idleTimeout)
+	}
+}
+
+// WithCodec 设置请求和响应body的编码方式，默认是msgpack
+func WithCodec(codec brpc.Codec) option {
+	return func(c *Client) {
+		c.SetCodec(codec)
+	}
+}
+
+// WithCompression 开启压缩，见brpc.Client.SetCompression
+func WithCompression(threshold int, maxSize int) option {
+	return func(c *Client) {
+		c.SetCompression(threshold, maxSize)
+	}
+}
+
+func WithMetrics(metrics brpc.Metrics) option {
+	return func(c *Client) {
+		c.SetMetrics(metrics)
+	}
+}
+
+func WithInterceptors(interceptors ...brpc.ClientInterceptor) option {
+	return func(c *Client) {
+		c.AddInterceptors(interceptors...)
+	}
+}
+
 func WithServerPublicKey(pk bcrypt.NoisePublicKey) option {
 	return func(c *Client) {
 		c.SetServerPublicKey(pk)
@@ -47,28 +173,85 @@ func WithClientPrivateKey(pk bcrypt.NoisePrivateKey) option {
 	}
 }
 
+func (c *Client) Close() error {
+	c.muxLock.Lock()
+	defer c.muxLock.Unlock()
+
+	if c.muxConn != nil {
+		c.muxConn.close(errMuxConnClosed)
+		c.muxConn = nil
+	}
+	c.muxDial = nil
+
+	if c.pool != nil {
+		c.pool.close()
+	}
+	return nil
+}
+
 func (c *Client) Call(serviceName string, req any, resp any) error {
 	return c.CallContext(context.Background(), serviceName, req, resp)
 }
 
 func (c *Client) CallContext(ctx context.Context, serviceName string, req any, resp any) error {
+	return c.Client.Invoke(ctx, serviceName, req, resp, c.invoke)
+}
+
+func (c *Client) invoke(ctx context.Context, serviceName string, req any, resp any) error {
 	var (
 		ePubKey bcrypt.NoisePublicKey
 		t       int64
 	)
 
-	data, err := c.Client.WriteRequestMessage(nil, serviceName, req, &ePubKey, &t)
+	data, err := c.Client.WriteRequestMessageContext(ctx, nil, serviceName, req, &ePubKey, &t)
 	if err != nil {
 		return err
 	}
-	dataLen := uint16(len(data))
 
-	rwc, err := c.open()
+	if len(data) > c.maxMessageSize {
+		return brpc.ErrMessageTooLarge
+	}
+
+	if c.mux {
+		return c.callMux(ctx, resp, data, &ePubKey, t)
+	}
+
+	if c.pool != nil {
+		return c.callPool(ctx, resp, data, &ePubKey, t)
+	}
+
+	if !c.legacy && !c.legacyServer.Load() {
+		err := c.callOwnConn(ctx, resp, data, &ePubKey, t)
+		if err != ErrFramingUnsupported {
+			return err
+		}
+		// 握手超时时请求还没有发出去，可以用老协议重新发送
+		c.legacyServer.Store(true)
+	}
+
+	if len(data) > math.MaxUint16 {
+		return brpc.ErrMessageTooLarge
+	}
+
+	rwc, err := c.open(ctx)
 	if err != nil {
 		return err
 	}
 	defer rwc.Close()
 
+	// ctx结束时关闭连接，让阻塞的读写立即返回
+	stop := context.AfterFunc(ctx, func() { rwc.Close() })
+	defer stop()
+
+	err = c.callOnce(ctx, rwc, resp, data, &ePubKey, t)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (c *Client) callOnce(ctx context.Context, rwc io.ReadWriter, resp any, data []byte, ePubKey *bcrypt.NoisePublicKey, t int64) error {
+	dataLen := uint16(len(data))
 	if _, err := rwc.Write(ePubKey[:]); err != nil {
 		return err
 	}
@@ -93,19 +276,182 @@ func (c *Client) CallContext(ctx context.Context, serviceName string, req any, r
 		return err
 	}
 
-	if time.Now().Unix()-t > 3*60 {
-		return errors.New("response expired, sync time with server")
-	}
-
 	if err := binary.Read(rwc, binary.BigEndian, &dataLen); err != nil {
 		return err
 	}
 
+	if int(dataLen) > c.maxMessageSize {
+		return brpc.ErrMessageTooLarge
+	}
+
 	buffer := bytes.NewBuffer(data[:0])
 	if _, err := io.CopyN(buffer, rwc, int64(dataLen)); err != nil {
 		return err
 	}
 
 	data = buffer.Bytes()
-	return c.Client.ReadResponseMessage(resp, data, &ePubKey, t)
+
+	// 临时公钥全为0表示请求被拒绝，数据是明文的拒绝原因
+	if *ePubKey == (bcrypt.NoisePublicKey{}) {
+		return brpc.RejectionError(string(data))
+	}
+
+	if time.Now().Unix()-t > 3*60 {
+		return errors.New("response expired, sync time with server")
+	}
+
+	return c.Client.ReadResponseMessageContext(ctx, resp, data, ePubKey, t)
+}
+
+func (c *Client) callMux(ctx context.Context, resp any, data []byte, ePubKey *bcrypt.NoisePublicKey, t int64) error {
+	conn, err := c.getMuxConn(ctx)
+	if err != nil {
+		return err
+	}
+	return c.callFrame(ctx, conn, resp, data, ePubKey, t)
+}
+
+func (c *Client) callPool(ctx context.Context, resp any, data []byte, ePubKey *bcrypt.NoisePublicKey, t int64) error {
+	conn, err := c.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.pool.put(conn)
+	return c.callFrame(ctx, conn, resp, data, ePubKey, t)
+}
+
+// callOwnConn 单独打开一个多路复用协议的连接完成调用
+func (c *Client) callOwnConn(ctx context.Context, resp any, data []byte, ePubKey *bcrypt.NoisePublicKey, t int64) error {
+	conn, err := c.openMuxConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.close(errMuxConnClosed)
+	return c.callFrame(ctx, conn, resp, data, ePubKey, t)
+}
+
+func (c *Client) callFrame(ctx context.Context, conn *muxConn, resp any, data []byte, ePubKey *bcrypt.NoisePublicKey, t int64) error {
+	f := &brpc.Frame{
+		Type:    brpc.FrameRequest,
+		EPubKey: *ePubKey,
+		T:       t,
+		Data:    data,
+	}
+	f, err := conn.roundTrip(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	if f.Type == brpc.FrameReject {
+		return brpc.RejectionError(string(f.Data))
+	}
+
+	if f.Type != brpc.FrameResponse {
+		return brpc.ErrFrameType
+	}
+
+	if time.Now().Unix()-f.T > 3*60 {
+		return errors.New("response expired, sync time with server")
+	}
+
+	return c.Client.ReadResponseMessageContext(ctx, resp, f.Data, &f.EPubKey, f.T)
+}
+
+// muxDial 是正在打开的多路复用连接，同时需要连接的调用都等待同一次打开
+type muxDial struct {
+	done chan struct{}
+	conn *muxConn
+	err  error
+}
+
+// getMuxConn 返回共用的连接，没有时打开新的连接。打开连接时不持有锁，
+// 等待的调用可以随时通过ctx放弃
+func (c *Client) getMuxConn(ctx context.Context) (*muxConn, error) {
+	c.muxLock.Lock()
+	if c.muxConn != nil && !c.muxConn.broken() {
+		conn := c.muxConn
+		c.muxLock.Unlock()
+		return conn, nil
+	}
+
+	d := c.muxDial
+	if d == nil {
+		d = &muxDial{done: make(chan struct{})}
+		c.muxDial = d
+		// 连接是共用的，不能因为发起打开的调用放弃了就让其他等待的调用都失败
+		go c.dialMux(context.WithoutCancel(ctx), d)
+	}
+	c.muxLock.Unlock()
+
+	select {
+	case <-d.done:
+		return d.conn, d.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) dialMux(ctx context.Context, d *muxDial) {
+	conn, err := c.openMuxConn(ctx)
+
+	c.muxLock.Lock()
+	if c.muxDial == d {
+		c.muxDial = nil
+		if err == nil {
+			c.muxConn = conn
+		}
+	} else if err == nil {
+		// 打开的过程中调用了Close
+		conn.close(errMuxConnClosed)
+	}
+	c.muxLock.Unlock()
+
+	d.conn, d.err = conn, err
+	close(d.done)
+}
+
+func (c *Client) openMuxConn(ctx context.Context) (*muxConn, error) {
+	rwc, err := c.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := newMuxConn(ctx, rwc, brpc.FrameVersion2, c.maxMessageSize, c.handshakeTimeout)
+	if err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func openContext(ctx context.Context, open OpenFunc) (io.ReadWriteCloser, error) {
+	if ctx.Done() == nil {
+		return open()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		rwc io.ReadWriteCloser
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		rwc, err := open()
+		ch <- result{rwc: rwc, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.rwc, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.rwc != nil {
+				r.rwc.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
 }
@@ -0,0 +1,204 @@
+package rw_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestLargeMessage(t *testing.T) {
+	ts := newTestServer(t)
+
+	big := Blob{Data: make([]byte, 1<<20)}
+	big.Data[12345] = 7
+
+	modes := []struct {
+		name string
+		opts []func(*rw.Client)
+	}{
+		{"conn", nil},
+		{"mux", []func(*rw.Client){rw.WithMux()}},
+		{"pool", []func(*rw.Client){rw.WithPool(1, 0, 0)}},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			c := ts.client(t, mode.opts...)
+			var out Blob
+			if err := c.Call("service.Echo", big, &out); err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Data) != len(big.Data) || out.Data[12345] != 7 {
+				t.Fatal("response corrupted")
+			}
+		})
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	ts := newTestServer(t)
+	big := Blob{Data: make([]byte, 1<<20)}
+
+	// 老协议最大64KiB
+	legacy := ts.client(t, rw.WithLegacyProtocol())
+	var out Blob
+	if err := legacy.Call("service.Echo", big, &out); err != brpc.ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+	if err := legacy.Call("service.Echo", Blob{Data: make([]byte, 1000)}, &out); err != nil || len(out.Data) != 1000 {
+		t.Fatal(err)
+	}
+
+	small := ts.client(t, rw.WithMux(), rw.WithMaxMessageSize(1000))
+	if err := small.Call("service.Echo", big, &out); err != brpc.ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+
+	// 服务端的上限
+	ts.SetMaxMessageSize(1000)
+	c := ts.client(t)
+	if err := c.Call("service.Echo", big, &out); err != brpc.ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+}
+
+func TestCallContextCancel(t *testing.T) {
+	ts := newTestServer(t)
+	modes := []struct {
+		name string
+		opts []func(*rw.Client)
+	}{
+		{"conn", nil},
+		{"legacy", []func(*rw.Client){rw.WithLegacyProtocol()}},
+		{"mux", []func(*rw.Client){rw.WithMux()}},
+		{"pool", []func(*rw.Client){rw.WithPool(1, 0, 0)}},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			c := ts.client(t, mode.opts...)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			var out int
+			if err := c.CallContext(ctx, "service.Sleep", 5*time.Second, &out); err != context.DeadlineExceeded {
+				t.Fatal(err)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Fatal("returned after", d)
+			}
+
+			// 取消的调用不影响之后的调用
+			if err := c.Call("service.Sleep", time.Millisecond, &out); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOpenContextCancel(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	ts := newTestServer(t)
+	c := ts.client(t, rw.WithOpen(func() (io.ReadWriteCloser, error) {
+		<-hang
+		return nil, io.EOF
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var out int
+	if err := c.CallContext(ctx, "service.Sleep", 0, &out); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestMuxDialNotBlocking(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	ts := newTestServer(t)
+	c := ts.client(t, rw.WithMux(), rw.WithOpenContext(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		<-hang
+		return nil, io.EOF
+	}))
+
+	// 第一个调用卡在建立连接上，后面的调用仍然能按自己的ctx返回
+	go c.Call("service.Query", Req{}, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.CallContext(ctx, "service.Query", Req{}, nil); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatal("returned after", d)
+	}
+}
+
+// serveOldVersion 模拟不支持多路复用协议的老版本服务端，先读满老协议42字节的头部再处理，
+// 收到握手时只会一直等待
+func serveOldVersion(t *testing.T, s *brpc.Server) rw.OpenFunc {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var head [42]byte
+				if _, err := io.ReadFull(conn, head[:]); err != nil {
+					return
+				}
+				s.ServeConn(struct {
+					io.Reader
+					io.Writer
+				}{io.MultiReader(bytes.NewReader(head[:]), conn), conn})
+			}()
+		}
+	}()
+	return func() (io.ReadWriteCloser, error) {
+		return net.Dial("tcp", l.Addr().String())
+	}
+}
+
+func TestOldServerFallback(t *testing.T) {
+	ts := newTestServer(t)
+	open := serveOldVersion(t, ts.Server)
+
+	c := ts.client(t, rw.WithOpen(open), rw.WithHandshakeTimeout(100*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		var out Resp
+		if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil || out.Age != 100 {
+			t.Fatal(i, err, out)
+		}
+	}
+
+	// 老协议放不下的请求返回明确的错误
+	var out Blob
+	if err := c.Call("service.Echo", Blob{Data: make([]byte, 1<<17)}, &out); err != brpc.ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+
+	// 只能使用多路复用协议的方式不会一直等待
+	mux := ts.client(t, rw.WithOpen(open), rw.WithHandshakeTimeout(100*time.Millisecond), rw.WithMux())
+	if err := mux.Call("service.Query", Req{Name: "admin"}, nil); err != rw.ErrFramingUnsupported {
+		t.Fatal(err)
+	}
+	if _, err := c.NewStream(context.Background(), "log.Tail"); err != rw.ErrFramingUnsupported {
+		t.Fatal(err)
+	}
+}
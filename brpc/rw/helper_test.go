@@ -0,0 +1,92 @@
+package rw_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+type Service struct{}
+
+type Req struct{ Name string }
+
+type Resp struct{ Age int }
+
+type Blob struct{ Data []byte }
+
+func (s *Service) Query(req Req, resp *Resp) error {
+	if req.Name != "admin" {
+		return errors.New("unknown name")
+	}
+	resp.Age = 100
+	return nil
+}
+
+func (s *Service) Echo(req Blob, resp *Blob) error {
+	resp.Data = req.Data
+	return nil
+}
+
+func (s *Service) Sleep(d time.Duration, resp *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+// testServer 是在本地端口上监听的服务端，注册了service，客户端密钥已经添加
+type testServer struct {
+	*brpc.Server
+	clientKey bcrypt.NoisePrivateKey
+	serverKey bcrypt.NoisePublicKey
+	listener  net.Listener
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	spk, err := bcrypt.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpk, err := bcrypt.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := brpc.NewServer()
+	s.SetServerPrivateKey(spk)
+	s.AddClientPublicKey(cpk.PublicKey())
+	if err := s.RegisterName("service", &Service{}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.ServeListener(l, nil)
+
+	return &testServer{Server: s, clientKey: cpk, serverKey: spk.PublicKey(), listener: l}
+}
+
+func (ts *testServer) dial() (io.ReadWriteCloser, error) {
+	return net.Dial("tcp", ts.listener.Addr().String())
+}
+
+// client 返回连接ts的rw.Client，opts在默认的密钥和连接方式之后生效
+func (ts *testServer) client(t *testing.T, opts ...func(*rw.Client)) *rw.Client {
+	opts = append([]func(*rw.Client){
+		rw.WithClientPrivateKey(ts.clientKey),
+		rw.WithServerPublicKey(ts.serverKey),
+		rw.WithOpen(ts.dial),
+	}, opts...)
+	c := rw.NewClient(opts...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
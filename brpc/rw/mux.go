@@ -0,0 +1,216 @@
+package rw
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+var errMuxConnClosed = errors.New("mux connection closed")
+
+// muxConn 在一个连接上同时发起多个请求，响应通过请求ID分发给对应的调用方。
+// 读写各有一个goroutine，调用方只在channel上等待，所以随时可以通过ctx放弃等待。
+type muxConn struct {
+	rwc     io.ReadWriteCloser
+	version byte
+	maxSize int
+	writeCh chan *brpc.Frame
+
+	lock    sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan muxResult
+	streams map[uint32]*Stream
+	err     error
+	done    chan struct{}
+}
+
+type muxResult struct {
+	f   *brpc.Frame
+	err error
+}
+
+// newMuxConn 完成握手后返回连接，握手完成之前不发送任何帧，
+// 老版本的服务端只会等待剩下的请求数据，既不回复也不关闭连接，只能靠handshakeTimeout判断
+func newMuxConn(ctx context.Context, rwc io.ReadWriteCloser, version byte, maxSize int, handshakeTimeout time.Duration) (*muxConn, error) {
+	hctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	stop := context.AfterFunc(hctx, func() { rwc.Close() })
+	err := brpc.WriteHandshake(rwc, version)
+	if err == nil {
+		var v byte
+		v, err = brpc.ReadHandshake(rwc)
+		if err == nil && v != version {
+			err = brpc.ErrFrameVersion
+		}
+	}
+	if !stop() {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ErrFramingUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &muxConn{
+		rwc:     rwc,
+		version: version,
+		maxSize: maxSize,
+		writeCh: make(chan *brpc.Frame),
+		pending: make(map[uint32]chan muxResult),
+		streams: make(map[uint32]*Stream),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.writeLoop()
+	return c, nil
+}
+
+func (c *muxConn) readLoop() {
+	var err error
+	for err == nil {
+		f := new(brpc.Frame)
+		err = brpc.ReadFrame(c.rwc, c.version, c.maxSize, f)
+		if err != nil && err != brpc.ErrMessageTooLarge {
+			break
+		}
+
+		c.lock.Lock()
+		ch, ok := c.pending[f.ID]
+		delete(c.pending, f.ID)
+		stream := c.streams[f.ID]
+		if f.Type == brpc.FrameStreamEnd || f.Type == brpc.FrameReject {
+			delete(c.streams, f.ID)
+		}
+		c.lock.Unlock()
+
+		if ok {
+			ch <- muxResult{f: f, err: err}
+		} else if stream != nil {
+			stream.deliver(muxResult{f: f, err: err})
+		}
+		err = nil
+	}
+
+	if err == io.EOF {
+		err = errMuxConnClosed
+	}
+	c.close(err)
+}
+
+func (c *muxConn) writeLoop() {
+	for {
+		select {
+		case f := <-c.writeCh:
+			if err := brpc.WriteFrame(c.rwc, c.version, f); err != nil {
+				c.close(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *muxConn) close(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.rwc.Close()
+	close(c.done)
+}
+
+func (c *muxConn) broken() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err != nil
+}
+
+// writeFrame 把帧交给写goroutine，交出去之后就一定会被发送
+func (c *muxConn) writeFrame(ctx context.Context, f *brpc.Frame) error {
+	select {
+	case c.writeCh <- f:
+		return nil
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *muxConn) openStream(ctx context.Context, f *brpc.Frame, stream *Stream) error {
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return c.err
+	}
+	c.nextID++
+	f.ID = c.nextID
+	stream.key.ID = f.ID
+	c.streams[f.ID] = stream
+	c.lock.Unlock()
+
+	if err := c.writeFrame(ctx, f); err != nil {
+		c.removeStream(f.ID)
+		return err
+	}
+	return nil
+}
+
+func (c *muxConn) removeStream(id uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.streams, id)
+}
+
+func (c *muxConn) roundTrip(ctx context.Context, f *brpc.Frame) (*brpc.Frame, error) {
+	ch := make(chan muxResult, 1)
+
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	f.ID = c.nextID
+	c.pending[f.ID] = ch
+	c.lock.Unlock()
+
+	if err := c.writeFrame(ctx, f); err != nil {
+		c.removePending(f.ID)
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return r.f, r.err
+	case <-ctx.Done():
+		c.removePending(f.ID)
+		return nil, ctx.Err()
+	case <-c.done:
+	}
+
+	// 连接关闭前响应可能已经到了
+	select {
+	case r := <-ch:
+		return r.f, r.err
+	default:
+		return nil, c.err
+	}
+}
+
+func (c *muxConn) removePending(id uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.pending, id)
+}
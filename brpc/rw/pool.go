@@ -0,0 +1,158 @@
+package rw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const DefaultPoolMaxIdle = 2
+
+// ErrPoolClosed 是WithPool模式下Client.Close之后发起调用返回的错误
+var ErrPoolClosed = errors.New("connection pool closed")
+
+// pool 是WithPool模式的连接池，连接使用多路复用协议，但每个连接同时只处理一个调用，
+// 调用结束后放回池中给下一个调用复用，出错断开的连接直接丢弃
+type pool struct {
+	open        func(ctx context.Context) (*muxConn, error)
+	maxIdle     int
+	idleTimeout time.Duration
+
+	// 正在使用的连接数的信号量，为nil时不限制
+	sem chan struct{}
+
+	lock   sync.Mutex
+	idle   []idleConn
+	timer  *time.Timer
+	closed bool
+}
+
+type idleConn struct {
+	conn  *muxConn
+	since time.Time
+}
+
+func newPool(open func(ctx context.Context) (*muxConn, error), maxIdle int, maxOpen int, idleTimeout time.Duration) *pool {
+	if maxIdle <= 0 {
+		maxIdle = DefaultPoolMaxIdle
+	}
+
+	p := &pool{
+		open:        open,
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+	if maxOpen > 0 {
+		p.sem = make(chan struct{}, maxOpen)
+	}
+	return p
+}
+
+// get 取出一个空闲的连接，没有时打开新的连接，打开的连接数达到上限时等待其他调用放回
+func (p *pool) get(ctx context.Context) (*muxConn, error) {
+	if p.sem != nil {
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	conn, err := p.getIdle()
+	if conn == nil && err == nil {
+		conn, err = p.open(ctx)
+	}
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// getIdle 取出最近放回的可用连接，顺便关闭已经断开和空闲太久的连接
+func (p *pool) getIdle() (*muxConn, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+
+	p.removeExpired(time.Now())
+	for len(p.idle) > 0 {
+		ic := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !ic.conn.broken() {
+			return ic.conn, nil
+		}
+	}
+	return nil, nil
+}
+
+// put 放回使用完的连接，断开的连接、超过空闲上限的连接和连接池关闭后放回的连接直接关闭
+func (p *pool) put(conn *muxConn) {
+	defer p.release()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed || conn.broken() || len(p.idle) >= p.maxIdle {
+		conn.close(errMuxConnClosed)
+		return
+	}
+
+	p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+	if p.idleTimeout > 0 && p.timer == nil {
+		p.timer = time.AfterFunc(p.idleTimeout, p.sweep)
+	}
+}
+
+func (p *pool) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+// sweep 定时关闭空闲太久的连接，还有空闲连接时等下一个最早过期的时间再检查
+func (p *pool) sweep() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.timer = nil
+	now := time.Now()
+	p.removeExpired(now)
+	if len(p.idle) > 0 {
+		p.timer = time.AfterFunc(p.idle[0].since.Add(p.idleTimeout).Sub(now), p.sweep)
+	}
+}
+
+// removeExpired 关闭空闲超过idleTimeout的连接，idle按放回的时间排序，最早的在前面
+func (p *pool) removeExpired(now time.Time) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+
+	n := 0
+	for n < len(p.idle) && now.Sub(p.idle[n].since) >= p.idleTimeout {
+		p.idle[n].conn.close(errMuxConnClosed)
+		n++
+	}
+	p.idle = append(p.idle[:0], p.idle[n:]...)
+}
+
+// close 关闭连接池和所有空闲的连接，使用中的连接在放回时关闭
+func (p *pool) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.closed = true
+	for _, ic := range p.idle {
+		ic.conn.close(errMuxConnClosed)
+	}
+	p.idle = nil
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
@@ -0,0 +1,133 @@
+package rw_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestPool(t *testing.T) {
+	ts := newTestServer(t)
+	var opened atomic.Int32
+	c := ts.client(t, rw.WithPool(2, 3, 300*time.Millisecond), rw.WithOpen(func() (io.ReadWriteCloser, error) {
+		opened.Add(1)
+		return ts.dial()
+	}))
+
+	// 依次调用时一直复用同一个连接
+	var out Resp
+	for i := 0; i < 10; i++ {
+		if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil || out.Age != 100 {
+			t.Fatal(err)
+		}
+	}
+	if n := opened.Load(); n != 1 {
+		t.Fatal("opened", n)
+	}
+
+	// 最多同时打开3个连接，多出来的调用等待
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out int
+			if err := c.Call("service.Sleep", 200*time.Millisecond, &out); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatal("calls not queued", d)
+	}
+	if n := opened.Load(); n != 3 {
+		t.Fatal("opened", n)
+	}
+
+	// 空闲超时的连接被关闭，之后的调用打开新连接
+	time.Sleep(700 * time.Millisecond)
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if n := opened.Load(); n != 4 {
+		t.Fatal("opened", n)
+	}
+
+	c.Close()
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != rw.ErrPoolClosed {
+		t.Fatal(err)
+	}
+	if n := opened.Load(); n != 4 {
+		t.Fatal("opened after close", n)
+	}
+}
+
+func TestPoolBrokenConn(t *testing.T) {
+	ts := newTestServer(t)
+	var (
+		lock  sync.Mutex
+		conns []net.Conn
+	)
+	c := ts.client(t, rw.WithPool(2, 0, 0), rw.WithOpen(func() (io.ReadWriteCloser, error) {
+		conn, err := net.Dial("tcp", ts.listener.Addr().String())
+		if err == nil {
+			lock.Lock()
+			conns = append(conns, conn)
+			lock.Unlock()
+		}
+		return conn, err
+	}))
+
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// 断开的空闲连接被丢弃，调用使用新连接
+	lock.Lock()
+	conns[0].Close()
+	lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(conns) != 2 {
+		t.Fatal("opened", len(conns))
+	}
+}
+
+func TestMuxPoolExclusive(t *testing.T) {
+	ts := newTestServer(t)
+	var opened atomic.Int32
+	open := rw.WithOpen(func() (io.ReadWriteCloser, error) {
+		opened.Add(1)
+		return ts.dial()
+	})
+
+	// 后设置的WithMux生效，所有调用共用一个连接
+	c := ts.client(t, open, rw.WithPool(1, 0, 0), rw.WithMux())
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out int
+			if err := c.Call("service.Sleep", 50*time.Millisecond, &out); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := opened.Load(); n != 1 {
+		t.Fatal("opened", n)
+	}
+}
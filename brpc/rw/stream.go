@@ -0,0 +1,272 @@
+package rw
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+	"github.com/abxuz/b-tools/v2/brpc"
+)
+
+var ErrStreamClosed = errors.New("stream closed")
+
+// Stream 是客户端一侧的流，Send和Recv可以在不同的goroutine里同时调用。
+// 服务端处理结束后Recv返回io.EOF，处理出错时返回对应的错误。
+// 未读的消息超过WithStreamRecvBuffer设置的条数时流会以brpc.ErrStreamOverflow结束。
+type Stream struct {
+	client  *Client
+	conn    *muxConn
+	ownConn bool
+	key     brpc.StreamKey
+	ctx     context.Context
+	stop    func() bool
+
+	recv      chan muxResult
+	recvSeq   uint64
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// 发送的消息按序号的顺序交给连接
+	writeLock sync.Mutex
+	sendSeq   uint64
+
+	lock sync.Mutex
+	err  error
+}
+
+// NewStream 打开一个流，WithMux模式下和普通调用共用连接，否则单独打开一个连接
+func (c *Client) NewStream(ctx context.Context, serviceName string) (*Stream, error) {
+	var (
+		ePubKey bcrypt.NoisePublicKey
+		t       int64
+	)
+
+	data, err := c.Client.WriteRequestMessageContext(ctx, nil, serviceName, nil, &ePubKey, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > c.maxMessageSize {
+		return nil, brpc.ErrMessageTooLarge
+	}
+
+	var (
+		conn    *muxConn
+		ownConn bool
+	)
+	if c.mux {
+		conn, err = c.getMuxConn(ctx)
+	} else {
+		conn, err = c.openMuxConn(ctx)
+		ownConn = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &Stream{
+		client:  c,
+		conn:    conn,
+		ownConn: ownConn,
+		key:     brpc.StreamKey{EPubKey: ePubKey},
+		ctx:     ctx,
+		recv:    make(chan muxResult, c.streamRecvBuffer),
+		closed:  make(chan struct{}),
+	}
+
+	f := &brpc.Frame{
+		Type:    brpc.FrameStreamOpen,
+		EPubKey: ePubKey,
+		T:       t,
+		Data:    data,
+	}
+	if err := conn.openStream(ctx, f, stream); err != nil {
+		if ownConn {
+			conn.close(errMuxConnClosed)
+		}
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { stream.Close() })
+	stream.lock.Lock()
+	stream.stop = stop
+	stream.lock.Unlock()
+	return stream, nil
+}
+
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Stream) Send(msg any) error {
+	if err := s.finalErr(); err != nil {
+		return err
+	}
+	return s.write(s.ctx, brpc.FrameStreamData, msg)
+}
+
+// CloseSend 告诉服务端不会再发送消息，服务端的Recv会收到io.EOF
+func (s *Stream) CloseSend() error {
+	if err := s.finalErr(); err != nil {
+		return err
+	}
+	return s.write(s.ctx, brpc.FrameStreamEnd, nil)
+}
+
+// Recv 接收服务端的下一条消息，流正常结束时返回io.EOF
+func (s *Stream) Recv(msg any) error {
+	if err := s.finalErr(); err != nil {
+		return err
+	}
+
+	var r muxResult
+	select {
+	case r = <-s.recv:
+	case <-s.closed:
+		return s.closedErr()
+	case <-s.conn.done:
+		select {
+		case r = <-s.recv:
+		default:
+			return s.finish(s.conn.err)
+		}
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+
+	f := r.f
+	if f.Type == brpc.FrameReject {
+		return s.finish(brpc.RejectionError(string(f.Data)))
+	}
+
+	if time.Now().Unix()-f.T > 3*60 {
+		return s.finish(errors.New("response expired, sync time with server"))
+	}
+
+	switch f.Type {
+	case brpc.FrameStreamData:
+		err := s.client.Client.ReadStreamMessage(msg, &s.key, &s.recvSeq, f.Data, &f.EPubKey, f.T)
+		if err == brpc.ErrStreamSequence {
+			return s.finish(err)
+		}
+		return err
+	case brpc.FrameStreamEnd:
+		err := s.client.Client.ReadStreamMessage(nil, &s.key, &s.recvSeq, f.Data, &f.EPubKey, f.T)
+		if err == nil {
+			err = io.EOF
+		}
+		return s.finish(err)
+	}
+	return s.finish(brpc.ErrFrameType)
+}
+
+// CloseAndRecv 用于客户端流，关闭发送后接收服务端唯一的一条响应
+func (s *Stream) CloseAndRecv(msg any) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+
+	if err := s.Recv(msg); err != nil {
+		return err
+	}
+
+	if err := s.Recv(nil); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected stream message")
+		}
+		return err
+	}
+	return nil
+}
+
+// Close 中止流，流已经结束时只释放资源
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		s.lock.Lock()
+		stop := s.stop
+		s.lock.Unlock()
+		if stop != nil {
+			stop()
+		}
+
+		s.conn.removeStream(s.key.ID)
+		if s.ownConn {
+			s.conn.close(errMuxConnClosed)
+		} else if s.finalErr() == nil {
+			// 共用的连接上通知服务端取消，不等待发送完成
+			go s.write(context.Background(), brpc.FrameStreamCancel, nil)
+		}
+	})
+	return nil
+}
+
+func (s *Stream) write(ctx context.Context, typ byte, msg any) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	f := &brpc.Frame{Type: typ, ID: s.key.ID}
+
+	var err error
+	f.Data, err = s.client.Client.WriteStreamMessage(nil, &s.key, s.sendSeq, msg, &f.EPubKey, &f.T)
+	if err != nil {
+		return err
+	}
+
+	if len(f.Data) > s.client.maxMessageSize {
+		return brpc.ErrMessageTooLarge
+	}
+	if err := s.conn.writeFrame(ctx, f); err != nil {
+		return err
+	}
+	s.sendSeq++
+	return nil
+}
+
+// deliver 在连接的读循环里调用，不能阻塞，Recv处理不过来时结束这个流
+func (s *Stream) deliver(r muxResult) {
+	select {
+	case s.recv <- r:
+	case <-s.closed:
+	default:
+		// finish之后Close不会再通知服务端，共用的连接上要自己发送取消
+		if !s.ownConn {
+			go s.write(context.Background(), brpc.FrameStreamCancel, nil)
+		}
+		s.finish(brpc.ErrStreamOverflow)
+	}
+}
+
+func (s *Stream) finalErr() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+func (s *Stream) finish(err error) error {
+	s.lock.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	err = s.err
+	s.lock.Unlock()
+
+	s.Close()
+	return err
+}
+
+func (s *Stream) closedErr() error {
+	if err := s.finalErr(); err != nil {
+		return err
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return ErrStreamClosed
+}
@@ -0,0 +1,52 @@
+package rw_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestStreamRecvOverflow(t *testing.T) {
+	ts := newTestServer(t)
+	stopped := make(chan error, 1)
+	ts.HandleStream("flood.Send", func(st *brpc.ServerStream) error {
+		for i := 0; ; i++ {
+			if err := st.Send(i); err != nil {
+				stopped <- err
+				return err
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	c := ts.client(t, rw.WithMux(), rw.WithStreamRecvBuffer(2))
+	st, err := c.NewStream(context.Background(), "flood.Send")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// 不Recv，缓存满了之后流结束，服务端也会被取消
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server stream not cancelled")
+	}
+	for {
+		if err = st.Recv(nil); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, brpc.ErrStreamOverflow) {
+		t.Fatal(err)
+	}
+
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+}
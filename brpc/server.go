@@ -2,86 +2,134 @@ package brpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"io"
+	"maps"
 	"net"
 	"net/http"
-	"net/rpc"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abxuz/b-tools/v2/bcrypt"
-	"github.com/vmihailenco/msgpack/v5"
 )
 
-type internalError error
+const (
+	DefaultMaxClockSkew    = 3 * time.Minute
+	DefaultMaxConnRequests = 128
+)
 
-type serverCodec struct {
-	RequestReader  io.Reader
-	ResponseWriter io.Writer
+type Server struct {
+	serverPrivKey     *bcrypt.NoisePrivateKey
+	clientPubKeys     map[string]*ClientInfo
+	clientPubKeysLock *sync.RWMutex
+	maxMessageSize    int
+	maxConnRequests   int
+	streamRecvBuffer  int
+	maxClockSkew      int64
+	replay            *replayCache
+	acl               *ACL
+	compression       *compression
+	limiter           *limiter
+	health            *Health
+	metrics           Metrics
+	livenessPath      string
+
+	methods     map[string]*method
+	services    map[string]bool
+	methodsLock *sync.RWMutex
+
+	streamHandlers     map[string]StreamHandler
+	streamHandlersLock *sync.RWMutex
+
+	interceptors []Interceptor
+
+	inShutdown atomic.Bool
+	listeners  map[*net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+	trackLock  *sync.Mutex
 }
 
-func (c *serverCodec) ReadRequestHeader(req *rpc.Request) error {
-	var tmp [1]uint8
-	_, err := io.ReadFull(c.RequestReader, tmp[:])
-	if err != nil {
-		return err
+func NewServer(opts ...serverOption) *Server {
+	s := &Server{
+		clientPubKeys:     make(map[string]*ClientInfo),
+		clientPubKeysLock: new(sync.RWMutex),
+		maxMessageSize:    DefaultMaxMessageSize,
+		maxConnRequests:   DefaultMaxConnRequests,
+		streamRecvBuffer:  DefaultStreamRecvBuffer,
+		maxClockSkew:      int64(DefaultMaxClockSkew / time.Second),
+		replay:            newReplayCache(DefaultReplayCacheSize),
+
+		methods:     make(map[string]*method),
+		services:    make(map[string]bool),
+		methodsLock: new(sync.RWMutex),
+
+		streamHandlers:     make(map[string]StreamHandler),
+		streamHandlersLock: new(sync.RWMutex),
+
+		listeners: make(map[*net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+		trackLock: new(sync.Mutex),
 	}
-
-	methodLen := int(tmp[0])
-	buff := make([]byte, methodLen)
-	_, err = io.ReadAtLeast(c.RequestReader, buff, methodLen)
-	if err != nil {
-		return err
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
+}
 
-	req.ServiceMethod = string(buff)
-	return nil
+func (s *Server) SetServerPrivateKey(pk bcrypt.NoisePrivateKey) {
+	s.serverPrivKey = &pk
 }
 
-func (c *serverCodec) ReadRequestBody(v any) error {
-	return msgpack.NewDecoder(c.RequestReader).Decode(v)
+// SetMaxMessageSize 设置请求和响应加密后数据的最大长度，超过的请求会被拒绝，
+// 超过的响应会被替换成ErrMessageTooLarge错误
+func (s *Server) SetMaxMessageSize(n int) {
+	s.maxMessageSize = n
 }
 
-func (c *serverCodec) WriteResponse(resp *rpc.Response, body any) error {
-	if resp.Error != "" {
-		_, err := c.ResponseWriter.Write([]byte{0xff})
-		if err != nil {
-			return err
-		}
-		_, err = io.WriteString(c.ResponseWriter, resp.Error)
-		return err
+// SetMaxConnRequests 设置多路复用连接上同时处理的请求数的上限，打开的流也计算在内。
+// 达到上限后暂停读取这个连接，直到有请求处理完，这时打开新的流会被拒绝，返回ErrTooManyStreams，
+// 不大于0时使用DefaultMaxConnRequests
+func (s *Server) SetMaxConnRequests(n int) {
+	if n <= 0 {
+		n = DefaultMaxConnRequests
 	}
+	s.maxConnRequests = n
+}
 
-	_, err := c.ResponseWriter.Write([]byte{0xfe})
-	if err != nil {
-		return err
+// SetStreamRecvBuffer 设置每个流最多缓存的客户端消息数，处理函数来不及Recv、缓存满了时
+// 流以ErrStreamOverflow结束，不大于0时使用DefaultStreamRecvBuffer
+func (s *Server) SetStreamRecvBuffer(n int) {
+	if n <= 0 {
+		n = DefaultStreamRecvBuffer
 	}
-	return msgpack.NewEncoder(c.ResponseWriter).Encode(body)
+	s.streamRecvBuffer = n
 }
 
-func (c *serverCodec) Close() error { return nil }
+// SetMaxClockSkew 设置允许的客户端时钟偏差，请求时间早于或晚于服务端时间超过这个值都会被拒绝，
+// 同时也是防重放记录的保存时间
+func (s *Server) SetMaxClockSkew(d time.Duration) {
+	s.maxClockSkew = int64(d / time.Second)
+}
 
-type Server struct {
-	rpcServer         *rpc.Server
-	serverPrivKey     *bcrypt.NoisePrivateKey
-	clientPubKeys     map[string]*bcrypt.NoisePublicKey
-	clientPubKeysLock *sync.RWMutex
+// SetReplayCacheSize 设置每个客户端密钥的防重放记录的最大条数，超过后这个客户端新的请求会被拒绝
+func (s *Server) SetReplayCacheSize(n int) {
+	s.replay.setMaxSize(n)
 }
 
-func NewServer() *Server {
-	s := &Server{
-		rpcServer:         rpc.NewServer(),
-		clientPubKeys:     make(map[string]*bcrypt.NoisePublicKey),
-		clientPubKeysLock: new(sync.RWMutex),
-	}
-	return s
+// SetACL 设置访问控制，为nil时所有客户端都可以调用所有方法
+func (s *Server) SetACL(acl *ACL) {
+	s.acl = acl
 }
 
-func (s *Server) SetServerPrivateKey(pk bcrypt.NoisePrivateKey) {
-	s.serverPrivKey = &pk
+// SetCompression 开启压缩，服务端可以解压客户端压缩过的请求，客户端也开启压缩时，
+// 编码后达到threshold字节的响应body会被压缩，maxSize是解压后的最大长度，
+// 不大于0时使用DefaultMaxDecompressedSize
+func (s *Server) SetCompression(threshold int, maxSize int) {
+	s.compression = newCompression(threshold, maxSize)
 }
 
 func (s *Server) AddClientPublicKey(pks ...bcrypt.NoisePublicKey) {
@@ -90,10 +138,19 @@ func (s *Server) AddClientPublicKey(pks ...bcrypt.NoisePublicKey) {
 
 	for _, pk := range pks {
 		h := string(hash(pk[:]))
-		s.clientPubKeys[h] = &pk
+		s.clientPubKeys[h] = &ClientInfo{PublicKey: pk}
 	}
 }
 
+// AddClient 添加客户端公钥，metadata会通过ClientInfo传给处理请求的方法
+func (s *Server) AddClient(pk bcrypt.NoisePublicKey, metadata map[string]string) {
+	s.clientPubKeysLock.Lock()
+	defer s.clientPubKeysLock.Unlock()
+
+	h := string(hash(pk[:]))
+	s.clientPubKeys[h] = &ClientInfo{PublicKey: pk, Metadata: maps.Clone(metadata)}
+}
+
 func (s *Server) ClearClientPublicKey() {
 	s.clientPubKeysLock.Lock()
 	defer s.clientPubKeysLock.Unlock()
@@ -108,47 +165,50 @@ func (s *Server) RemoveClientPublicKey(pk bcrypt.NoisePublicKey) {
 	delete(s.clientPubKeys, h)
 }
 
-func (s *Server) Register(rcvr any) error {
-	return s.rpcServer.Register(rcvr)
-}
-
-func (s *Server) RegisterName(name string, rcvr any) error {
-	return s.rpcServer.RegisterName(name, rcvr)
-}
-
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.livenessPath != "" && req.URL.Path == s.livenessPath && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		s.serveLiveness(w)
+		return
+	}
+
 	headerE := req.Header.Get("X-Rpc-E")
 	headerT := req.Header.Get("X-Rpc-T")
 	if headerE == "" || headerT == "" {
-		w.WriteHeader(http.StatusForbidden)
+		s.rejectHTTP(w, ErrRequestRejected)
 		return
 	}
 
 	t, err := strconv.ParseInt(headerT, 10, 64)
-	if err != nil || time.Now().Unix()-t > 3*60 {
-		w.WriteHeader(http.StatusForbidden)
+	if err != nil {
+		s.rejectHTTP(w, ErrRequestRejected)
+		return
+	}
+
+	if err := s.checkTime(t); err != nil {
+		s.rejectHTTP(w, err)
 		return
 	}
 
 	var ePubKey bcrypt.NoisePublicKey
 	if err := ePubKey.FromString(headerE); err != nil {
-		w.WriteHeader(http.StatusForbidden)
+		s.rejectHTTP(w, ErrRequestRejected)
 		return
 	}
 
-	data, err := io.ReadAll(req.Body)
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, int64(s.maxMessageSize)))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			s.rejectHTTP(w, ErrMessageTooLarge)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 		return
 	}
 
-	data, err = s.process(&ePubKey, t, data, &ePubKey, &t)
+	data, err = s.process(req.Context(), &ePubKey, t, data, s.maxMessageSize, &ePubKey, &t)
 	if err != nil {
-		if _, ok := err.(internalError); ok {
-			w.WriteHeader(http.StatusInternalServerError)
-		} else {
-			w.WriteHeader(http.StatusForbidden)
-		}
+		s.rejectHTTP(w, err)
 		return
 	}
 
@@ -157,25 +217,72 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	w.Write(data)
 }
 
+// rejectHTTP 用状态码和X-Rpc-Error头告诉客户端请求被拒绝的原因
+func (s *Server) rejectHTTP(w http.ResponseWriter, err error) {
+	reason := s.rejected(err)
+	w.Header().Set("X-Rpc-Error", reason)
+	w.WriteHeader(rejectStatusCode(reason))
+}
+
+// ServeListener 在l上接受连接并处理，Shutdown或者Close之后返回ErrServerClosed
 func (s *Server) ServeListener(l net.Listener, connCallback func(net.Conn) error) error {
+	if !s.trackListener(&l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(&l, false)
+
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
 			return err
 		}
+
+		sc := &serverConn{Conn: conn}
+		if !s.trackConn(sc, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
 		go func() {
-			defer conn.Close()
+			defer s.trackConn(sc, false)
+			defer sc.Close()
 			if connCallback != nil {
 				if err := connCallback(conn); err != nil {
 					return
 				}
 			}
-			s.ServeConn(conn)
+			s.serveConn(sc, sc)
 		}()
 	}
 }
 
 func (s *Server) ServeConn(rw io.ReadWriter) error {
+	return s.serveConn(rw, nil)
+}
+
+// sc是ServeListener接受的连接，用来让Shutdown知道连接上有没有处理中的请求
+func (s *Server) serveConn(rw io.ReadWriter, sc *serverConn) error {
+	var head [4]byte
+	_, err := io.ReadFull(rw, head[:])
+	if err != nil {
+		return err
+	}
+
+	if head == frameMagic {
+		return s.serveFrames(rw, sc)
+	}
+
+	if !sc.begin() {
+		return ErrServerClosed
+	}
+	defer sc.end()
+	return s.serveOnce(rw, head[:])
+}
+
+// 兼容老客户端，每个连接只处理一个请求，head是已经读出来的ePubKey的开头部分
+func (s *Server) serveOnce(rw io.ReadWriter, head []byte) error {
 	var (
 		ePubKey bcrypt.NoisePublicKey
 		t       int64
@@ -183,7 +290,8 @@ func (s *Server) ServeConn(rw io.ReadWriter) error {
 		data    []byte
 	)
 
-	_, err := io.ReadFull(rw, ePubKey[:])
+	n := copy(ePubKey[:], head)
+	_, err := io.ReadFull(rw, ePubKey[n:])
 	if err != nil {
 		return err
 	}
@@ -193,10 +301,6 @@ func (s *Server) ServeConn(rw io.ReadWriter) error {
 		return err
 	}
 
-	if time.Now().Unix()-t > 3*60 {
-		return errors.New("request expired")
-	}
-
 	err = binary.Read(rw, binary.BigEndian, &dataLen)
 	if err != nil {
 		return err
@@ -208,9 +312,13 @@ func (s *Server) ServeConn(rw io.ReadWriter) error {
 		return err
 	}
 
-	data, err = s.process(&ePubKey, t, data, &ePubKey, &t)
-	if err != nil {
-		return err
+	data, processErr := s.process(context.Background(), &ePubKey, t, data, min(s.maxMessageSize, legacyMaxMessageSize), &ePubKey, &t)
+	if processErr != nil {
+		// 拒绝时临时公钥全为0，数据是明文的拒绝原因。时间用当前时间，
+		// 不认识这个标记的老客户端才不会误报响应过期
+		ePubKey = bcrypt.NoisePublicKey{}
+		t = time.Now().Unix()
+		data = []byte(s.rejected(processErr))
 	}
 	dataLen = uint16(len(data))
 
@@ -226,63 +334,231 @@ func (s *Server) ServeConn(rw io.ReadWriter) error {
 		return err
 	}
 
-	_, err = rw.Write(data)
-	return err
+	if _, err = rw.Write(data); err != nil {
+		return err
+	}
+	return processErr
 }
 
+// maxSize是加密后响应数据的最大长度
 func (s *Server) process(
-	ePubKeyIn *bcrypt.NoisePublicKey, tIn int64, dataIn []byte,
+	ctx context.Context,
+	ePubKeyIn *bcrypt.NoisePublicKey, tIn int64, dataIn []byte, maxSize int,
 	ePubKeyOut *bcrypt.NoisePublicKey, tOut *int64,
 ) (dataOut []byte, err error) {
-	// 解密数据
-	data, err := decrypt(s.serverPrivKey, ePubKeyIn, tIn, dataIn)
+	if s.shuttingDown() {
+		return nil, ErrServerClosed
+	}
+
+	client, data, err := s.openRequest(ePubKeyIn, tIn, dataIn)
+	if err != nil {
+		return nil, err
+	}
+
+	data, h := splitHeader(data)
+
+	serviceName, err := readServiceName(data)
 	if err != nil {
 		return nil, err
 	}
 
+	// 先创建一个加密用的临时密钥，不然等rpc处理完了才发现有错，就很讨厌
+	ePrivKey, err := bcrypt.NewPrivateKey()
+	if err != nil {
+		return nil, internalError{err}
+	}
+
+	// 调用方法处理，并获取处理后的结果数据
+	ctx, cancel := h.withTimeout(newClientContext(ctx, client))
+	defer cancel()
+	ctx = newIncomingContext(ctx, h)
+	ctx, responseMetadata := newResponseMetadataContext(ctx)
+
+	start := time.Now()
+	call := &Call{ServiceName: serviceName, Client: client}
+	codec, data, bodyErr := s.readBody(h, data, len(serviceName)+1)
+	release, err := s.limiter.acquire(client.PublicKey)
+	if err == nil {
+		// 鉴权和解码在拦截器之前完成，拦截器调用next之前就能看到解码后的请求，例如根据参数鉴权
+		var m *method
+		checkErr := bodyErr
+		if checkErr == nil {
+			checkErr = s.authorize(client, serviceName)
+		}
+		if checkErr == nil {
+			m, call.Request, checkErr = s.decodeRequest(serviceName, codec, data[len(serviceName)+1:])
+		}
+
+		err = s.intercept(ctx, call, func(ctx context.Context, call *Call) error {
+			if checkErr != nil {
+				return checkErr
+			}
+
+			// 客户端已经不再等待结果了
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			return s.serveMethod(ctx, call, m)
+		})
+		release()
+
+		// 拦截器不调用next直接返回时，没通过检查的请求也只能得到检查的错误，
+		// 不能绕过访问控制，body有错误时也没有codec可以编码响应
+		if checkErr != nil {
+			err = checkErr
+		}
+	}
+	s.observeCall(serviceName, s.getMethod(serviceName) != nil, err, start)
+
+	// 新版本的客户端响应也带扩展头部
+	var rh *header
+	if h != nil && h.Version >= 2 {
+		rh = &header{AcceptCompression: s.compression.accept()}
+		if len(responseMetadata) > 0 {
+			rh.Metadata = responseMetadata.copyTo(nil)
+		}
+	}
+
+	responseWriter := bytes.NewBuffer(dataIn[:0])
+	if err != nil {
+		responseWriter.Write(h.appendError(nil, err))
+	} else {
+		data, err := codec.Marshal(call.Response)
+		if err != nil {
+			return nil, internalError{err}
+		}
+		if rh != nil {
+			data, rh.Compression = s.compression.compress(data, h.AcceptCompression)
+		}
+		responseWriter.WriteByte(0xfe)
+		responseWriter.Write(data)
+	}
+
+	if rh != nil {
+		if err := writeHeader(responseWriter, rh); err != nil {
+			return nil, internalError{err}
+		}
+	}
+
+	// 响应太长时替换成错误信息，避免客户端收到被截断的数据
+	if responseWriter.Len()+aeadOverhead > maxSize {
+		responseWriter.Reset()
+		responseWriter.WriteByte(0xff)
+		responseWriter.WriteString(ErrMessageTooLarge.Error())
+		if rh != nil {
+			rh.Compression = 0
+			rh.Metadata = nil
+			writeHeader(responseWriter, rh)
+		}
+	}
+
+	return s.sealMessage(&ePrivKey, &client.PublicKey, responseWriter.Bytes(), ePubKeyOut, tOut)
+}
+
+// readBody 根据扩展头部找到body的编码方式，并解压从offset开始的body
+func (s *Server) readBody(h *header, data []byte, offset int) (Codec, []byte, error) {
+	codec, err := h.codec()
+	if err != nil {
+		return nil, data, err
+	}
+
+	if h == nil || h.Compression == 0 {
+		return codec, data, nil
+	}
+
+	body, err := s.compression.decompress(data[offset:], h.Compression)
+	if err != nil {
+		return nil, data, err
+	}
+	return codec, append(data[:offset:offset], body...), nil
+}
+
+// openMessage 解密数据并验证客户端身份，返回客户端信息和去掉身份信息后的数据
+func (s *Server) openMessage(
+	ePubKey *bcrypt.NoisePublicKey, t int64, dataIn []byte,
+) (client *ClientInfo, dataOut []byte, err error) {
+	if err := s.checkTime(t); err != nil {
+		return nil, nil, err
+	}
+
+	// 解密数据
+	data, err := decrypt(s.serverPrivKey, ePubKey, t, dataIn)
+	if err != nil {
+		return nil, nil, ErrDecryptFailed
+	}
+
 	// 前8字节是ClientPublicKey的hash
 	// 后8字节是ClientPrivateKey * ServerPublicKey的hash
 	if len(data) < 16 {
-		return nil, io.ErrUnexpectedEOF
+		return nil, nil, io.ErrUnexpectedEOF
 	}
 
 	// 查看ClientPublicKey的hash是否在列表里
 	s.clientPubKeysLock.RLock()
-	clientPubKey, ok := s.clientPubKeys[string(data[:8])]
+	client, ok := s.clientPubKeys[string(data[:8])]
 	s.clientPubKeysLock.RUnlock()
 	if !ok {
-		return nil, errors.New("client public key invalid")
+		return nil, nil, ErrUnknownClient
 	}
 
 	// 验证ClientPublicKey是否有效
-	ss := s.serverPrivKey.SharedSecret(clientPubKey)
+	ss := s.serverPrivKey.SharedSecret(&client.PublicKey)
 	if !bcrypt.Equals(hash(ss[:]), data[8:16]) {
-		return nil, errors.New("client public key invalid")
+		return nil, nil, ErrUnknownClient
 	}
 
-	// 先创建一个加密用的临时密钥，不然等rpc处理完了才发现有错，就很讨厌
-	ePrivKey, err := bcrypt.NewPrivateKey()
+	return client, data[16:], nil
+}
+
+// openRequest 和openMessage一样，同时记录临时公钥防止请求被重放
+func (s *Server) openRequest(
+	ePubKey *bcrypt.NoisePublicKey, t int64, dataIn []byte,
+) (client *ClientInfo, dataOut []byte, err error) {
+	client, data, err := s.openMessage(ePubKey, t, dataIn)
 	if err != nil {
-		return nil, internalError(err)
+		return nil, nil, err
 	}
 
-	// 调用rpc处理，并获取处理后的结果数据
-	responseWriter := bytes.NewBuffer(dataIn[:0])
-	codec := &serverCodec{
-		RequestReader:  bytes.NewReader(data[16:]),
-		ResponseWriter: responseWriter,
+	// 验证通过后再记录临时公钥，避免伪造的请求把记录塞满
+	now := time.Now().Unix()
+	if err := s.replay.add(&client.PublicKey, ePubKey, t+s.maxClockSkew, now, s.maxClockSkew); err != nil {
+		return nil, nil, err
 	}
-	err = s.rpcServer.ServeRequest(codec)
-	if err != nil {
-		return nil, internalError(err)
+	return client, data, nil
+}
+
+func (s *Server) authorize(client *ClientInfo, serviceName string) error {
+	if s.acl != nil && !s.acl.Allowed(client.PublicKey, serviceName) {
+		return ErrPermissionDenied
 	}
+	return nil
+}
 
-	// 对结果数据进行加密
+func readServiceName(data []byte) (string, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(data[1 : 1+int(data[0])]), nil
+}
+
+func (s *Server) checkTime(t int64) error {
+	now := time.Now().Unix()
+	if t < now-s.maxClockSkew || t > now+s.maxClockSkew {
+		return ErrRequestExpired
+	}
+	return nil
+}
+
+// sealMessage 用临时密钥加密发给客户端的数据
+func (s *Server) sealMessage(
+	ePrivKey *bcrypt.NoisePrivateKey, clientPubKey *bcrypt.NoisePublicKey, data []byte,
+	ePubKeyOut *bcrypt.NoisePublicKey, tOut *int64,
+) (dataOut []byte, err error) {
 	t := time.Now().Unix()
-	data = responseWriter.Bytes()
-	data, err = encrypt(&ePrivKey, clientPubKey, t, data)
+	data, err = encrypt(ePrivKey, clientPubKey, t, data)
 	if err != nil {
-		return nil, internalError(err)
+		return nil, internalError{err}
 	}
 
 	*ePubKeyOut = ePrivKey.PublicKey()
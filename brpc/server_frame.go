@@ -0,0 +1,183 @@
+package brpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+// ErrTooManyStreams 表示连接上同时处理的请求和流已经达到上限，打开流的请求被拒绝，稍后可以重试
+var ErrTooManyStreams = errors.New("too many streams on connection, retry later")
+
+// ErrStreamIDInUse 表示打开流的请求使用了连接上正在使用的流ID
+var ErrStreamIDInUse = errors.New("stream id already in use")
+
+type frameConn struct {
+	rw        io.ReadWriter
+	version   byte
+	writeLock sync.Mutex
+
+	streams     map[uint32]*ServerStream
+	streamsLock sync.Mutex
+}
+
+func (c *frameConn) writeFrame(f *Frame) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return WriteFrame(c.rw, c.version, f)
+}
+
+// reject 拒绝请求，数据是明文的拒绝原因
+func (c *frameConn) reject(f *Frame, reason string) error {
+	f.Type = FrameReject
+	f.EPubKey = bcrypt.NoisePublicKey{}
+	f.T = 0
+	f.Data = []byte(reason)
+	return c.writeFrame(f)
+}
+
+func (c *frameConn) maxMessageSize(s *Server) int {
+	return int(min(int64(s.maxMessageSize), frameMaxDataLen(c.version)))
+}
+
+func (c *frameConn) getStream(id uint32) *ServerStream {
+	c.streamsLock.Lock()
+	defer c.streamsLock.Unlock()
+	return c.streams[id]
+}
+
+// addStream 记录新打开的流，ID已经被连接上的其他流使用时返回ErrStreamIDInUse
+func (c *frameConn) addStream(stream *ServerStream) error {
+	c.streamsLock.Lock()
+	defer c.streamsLock.Unlock()
+	if _, ok := c.streams[stream.key.ID]; ok {
+		return ErrStreamIDInUse
+	}
+	c.streams[stream.key.ID] = stream
+	return nil
+}
+
+func (c *frameConn) removeStream(id uint32) {
+	c.streamsLock.Lock()
+	defer c.streamsLock.Unlock()
+	delete(c.streams, id)
+}
+
+// 多路复用模式，一个连接上可以同时处理多个请求，响应通过请求ID对应，不保证顺序
+func (s *Server) serveFrames(rw io.ReadWriter, sc *serverConn) error {
+	var tmp [1]byte
+	_, err := io.ReadFull(rw, tmp[:])
+	if err != nil {
+		return err
+	}
+
+	version := tmp[0]
+	if err := checkFrameVersion(version); err != nil {
+		return err
+	}
+
+	if err := WriteHandshake(rw, version); err != nil {
+		return err
+	}
+
+	conn := &frameConn{
+		rw:      rw,
+		version: version,
+		streams: make(map[uint32]*ServerStream),
+	}
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+
+	// 限制连接上同时处理的请求和流的数量，达到上限时暂停读取，让客户端的写入阻塞
+	requests := make(chan struct{}, s.maxConnRequests)
+
+	// 连接断开后取消所有流和请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		f := new(Frame)
+		err := ReadFrame(rw, version, s.maxMessageSize, f)
+		if err == ErrMessageTooLarge {
+			if stream := conn.getStream(f.ID); stream != nil {
+				stream.cancel()
+			}
+			requests <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-requests }()
+				conn.reject(f, s.rejected(err))
+			}()
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch f.Type {
+		case FrameRequest:
+			requests <- struct{}{}
+			if !sc.begin() {
+				return ErrServerClosed
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-requests }()
+				defer sc.end()
+				s.serveFrame(ctx, conn, f)
+			}()
+		case FrameStreamOpen:
+			// 流不能等待，读循环停下来后已经打开的流也收不到消息了
+			select {
+			case requests <- struct{}{}:
+			default:
+				conn.reject(f, s.rejected(ErrTooManyStreams))
+				continue
+			}
+			if !sc.begin() {
+				return ErrServerClosed
+			}
+			// 流上的消息需要按顺序处理，所以在读循环里直接解密
+			stream, err := s.openStream(ctx, conn, f)
+			if err != nil {
+				conn.reject(f, s.rejected(err))
+				sc.end()
+				<-requests
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-requests }()
+				defer sc.end()
+				stream.serve()
+			}()
+		case FrameStreamData, FrameStreamEnd, FrameStreamCancel:
+			if stream := conn.getStream(f.ID); stream != nil {
+				stream.dispatch(f)
+			}
+		default:
+			return ErrFrameType
+		}
+	}
+}
+
+func (s *Server) serveFrame(ctx context.Context, conn *frameConn, f *Frame) {
+	var err error
+	f.Data, err = s.process(ctx, &f.EPubKey, f.T, f.Data, conn.maxMessageSize(s), &f.EPubKey, &f.T)
+	if err != nil {
+		conn.reject(f, s.rejected(err))
+		return
+	}
+
+	f.Type = FrameResponse
+	conn.writeFrame(f)
+}
@@ -0,0 +1,144 @@
+package brpc_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestConcurrentCalls(t *testing.T) {
+	ts := newTestServer(t)
+	for _, mode := range clientModes {
+		t.Run(mode.name, func(t *testing.T) {
+			c := ts.client(t, mode.opts...)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var resp Resp
+					if err := c.Call("service.Query", Req{Name: "admin"}, &resp); err != nil || resp.Age != 100 {
+						t.Error(err, resp)
+					}
+					err := c.Call("service.Query", Req{Name: "x"}, &resp)
+					if _, ok := err.(brpc.LogicError); !ok {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestMaxConnRequests(t *testing.T) {
+	ts := newTestServer(t)
+	ts.SetMaxConnRequests(2)
+
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	brpc.HandleFunc(ts.Server, "block.Wait", func(ctx context.Context, req *int) (*int, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		return req, nil
+	})
+
+	c := ts.client(t, rw.WithMux())
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out int
+			if err := c.Call("block.Wait", 1, &out); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := running.Load(); n != 2 {
+		t.Errorf("running = %v, want 2", n)
+	}
+	close(release)
+	wg.Wait()
+	if p := peak.Load(); p != 2 {
+		t.Errorf("peak = %v, want 2", p)
+	}
+}
+
+func TestMaxConnStreams(t *testing.T) {
+	ts := newTestServer(t)
+	ts.SetMaxConnRequests(2)
+	ts.HandleStream("echo", func(st *brpc.ServerStream) error {
+		var v int
+		for st.Recv(&v) == nil {
+			st.Send(v)
+		}
+		return nil
+	})
+
+	c := ts.client(t, rw.WithMux())
+	open := func() (*rw.Stream, error) {
+		st, err := c.NewStream(context.Background(), "echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		var v int
+		if err := st.Send(1); err != nil {
+			t.Fatal(err)
+		}
+		return st, st.Recv(&v)
+	}
+
+	first, err := open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 流占满了连接的上限，新的流被拒绝，已经打开的流不受影响
+	if _, err := open(); err != brpc.ErrTooManyStreams {
+		t.Fatal(err)
+	}
+	var v int
+	if err := first.Send(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Recv(&v); err != nil || v != 2 {
+		t.Fatal(err, v)
+	}
+
+	// 流结束后释放名额
+	first.CloseSend()
+	if err := first.Recv(nil); err != io.EOF {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		_, err := open()
+		if err == nil {
+			break
+		}
+		if err != brpc.ErrTooManyStreams || i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
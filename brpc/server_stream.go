@@ -0,0 +1,319 @@
+package brpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/bcrypt"
+)
+
+// StreamHandler 处理一个流式调用，返回后流结束，返回的错误会作为最终结果发给客户端。
+//
+// 服务端流: 先Recv一次拿到请求，再多次Send。
+// 客户端流: 一直Recv到io.EOF，再Send一次作为响应。
+// 双向流: 按需要交替调用Recv和Send，Recv和Send可以在不同的goroutine里调用。
+type StreamHandler = func(stream *ServerStream) error
+
+// DefaultStreamRecvBuffer 是流上默认最多缓存的未读消息数，接收方来不及处理、缓存满了时，
+// 为了不阻塞连接上的其他调用，这个流会以ErrStreamOverflow结束
+const DefaultStreamRecvBuffer = 64
+
+// ErrStreamOverflow 表示接收方处理得太慢，流的接收缓存满了
+var ErrStreamOverflow = NewError(CodeResourceExhausted, "brpc: stream receive buffer overflow")
+
+// ErrStreamSequence 表示流上收到了重复、乱序或者不属于这个流的消息，流会以这个错误结束
+var ErrStreamSequence = NewError(CodeInvalidArgument, "brpc: stream message out of sequence")
+
+// StreamKey 标识一个流，流上的每条消息都和它以及这个方向上的消息序号一起加密，
+// 防止消息被重放、调换顺序或者移到别的流上。EPubKey是打开流的请求的临时公钥，
+// 不同连接上ID相同的流也能区分开
+type StreamKey struct {
+	ID      uint32
+	EPubKey bcrypt.NoisePublicKey
+}
+
+// 流ID 4字节 + 打开流的临时公钥的hash 8字节 + 序号 8字节
+const streamPrefixLen = 4 + 8 + 8
+
+func (k *StreamKey) appendPrefix(dst []byte, seq uint64) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, k.ID)
+	dst = append(dst, hash(k.EPubKey[:])...)
+	return binary.BigEndian.AppendUint64(dst, seq)
+}
+
+// checkPrefix 验证消息属于这个流并且序号是seq，返回去掉前缀后的数据
+func (k *StreamKey) checkPrefix(data []byte, seq uint64) ([]byte, error) {
+	if len(data) < streamPrefixLen || !bytes.Equal(data[:streamPrefixLen], k.appendPrefix(nil, seq)) {
+		return nil, ErrStreamSequence
+	}
+	return data[streamPrefixLen:], nil
+}
+
+type ServerStream struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	server      *Server
+	conn        *frameConn
+	key         StreamKey
+	serviceName string
+	client      *ClientInfo
+	header      *header
+	codec       Codec
+	handler     StreamHandler
+
+	handlerCtx context.Context
+
+	// 客户端发来的消息，客户端调用CloseSend后关闭
+	recv       chan []byte
+	recvClosed bool
+	recvSeq    uint64
+
+	// 发送的消息按序号的顺序交给连接
+	sendLock sync.Mutex
+	sendSeq  uint64
+
+	// 接收缓存满了或者收到乱序的消息时流结束的原因
+	aborted atomic.Pointer[Error]
+
+	// 没通过check时的错误，这时拦截器也不能在流上收发消息
+	checkErr error
+}
+
+func (s *Server) HandleStream(name string, handler StreamHandler) {
+	s.streamHandlersLock.Lock()
+	defer s.streamHandlersLock.Unlock()
+	s.streamHandlers[name] = handler
+}
+
+func (s *Server) openStream(ctx context.Context, conn *frameConn, f *Frame) (*ServerStream, error) {
+	if s.shuttingDown() {
+		return nil, ErrServerClosed
+	}
+
+	client, data, err := s.openRequest(&f.EPubKey, f.T, f.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, h := splitHeader(data)
+
+	serviceName, err := readServiceName(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.streamHandlersLock.RLock()
+	handler := s.streamHandlers[serviceName]
+	s.streamHandlersLock.RUnlock()
+
+	stream := &ServerStream{
+		server:      s,
+		conn:        conn,
+		key:         StreamKey{ID: f.ID, EPubKey: f.EPubKey},
+		serviceName: serviceName,
+		client:      client,
+		header:      h,
+		handler:     handler,
+		recv:        make(chan []byte, s.streamRecvBuffer),
+	}
+	stream.ctx, stream.cancel = h.withTimeout(newIncomingContext(newClientContext(ctx, client), h))
+	if err := conn.addStream(stream); err != nil {
+		stream.cancel()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (st *ServerStream) Context() context.Context {
+	if st.handlerCtx != nil {
+		return st.handlerCtx
+	}
+	return st.ctx
+}
+
+func (st *ServerStream) ServiceName() string {
+	return st.serviceName
+}
+
+func (st *ServerStream) Client() *ClientInfo {
+	return st.client
+}
+
+// Recv 接收客户端的下一条消息，客户端调用CloseSend后返回io.EOF
+func (st *ServerStream) Recv(msg any) error {
+	if st.checkErr != nil {
+		return st.checkErr
+	}
+
+	select {
+	case data, ok := <-st.recv:
+		if !ok {
+			return io.EOF
+		}
+		return st.codec.Unmarshal(data, msg)
+	case <-st.ctx.Done():
+		return st.ctxErr()
+	}
+}
+
+func (st *ServerStream) Send(msg any) error {
+	if st.checkErr != nil {
+		return st.checkErr
+	}
+	if err := st.ctxErr(); err != nil {
+		return err
+	}
+
+	data, err := st.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return st.write(FrameStreamData, append([]byte{0xfe}, data...))
+}
+
+func (st *ServerStream) write(typ byte, data []byte) error {
+	ePrivKey, err := bcrypt.NewPrivateKey()
+	if err != nil {
+		return err
+	}
+
+	st.sendLock.Lock()
+	defer st.sendLock.Unlock()
+
+	f := &Frame{Type: typ, ID: st.key.ID}
+	data = append(st.key.appendPrefix(nil, st.sendSeq), data...)
+	f.Data, err = st.server.sealMessage(&ePrivKey, &st.client.PublicKey, data, &f.EPubKey, &f.T)
+	if err != nil {
+		return err
+	}
+
+	if len(f.Data) > st.conn.maxMessageSize(st.server) {
+		return ErrMessageTooLarge
+	}
+	if err := st.conn.writeFrame(f); err != nil {
+		return err
+	}
+	st.sendSeq++
+	return nil
+}
+
+func (st *ServerStream) serve() {
+	defer st.cancel()
+	defer st.conn.removeStream(st.key.ID)
+
+	start := time.Now()
+	call := &Call{ServiceName: st.serviceName, Client: st.client, Stream: st}
+	release, err := st.server.limiter.acquire(st.client.PublicKey)
+	if err == nil {
+		st.checkErr = st.check()
+		err = st.server.intercept(st.ctx, call, func(ctx context.Context, call *Call) error {
+			if st.checkErr != nil {
+				return st.checkErr
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// 拦截器可能替换了ctx，处理函数通过Context拿到的应该是替换后的
+			st.handlerCtx = ctx
+			return st.handler(st)
+		})
+		release()
+
+		// 和普通调用一样，拦截器不能让没通过检查的流绕过访问控制
+		if st.checkErr != nil {
+			err = st.checkErr
+		}
+	}
+
+	// 接收缓存满了或者收到乱序消息的流要告诉客户端原因
+	if aborted := st.aborted.Load(); aborted != nil {
+		err = aborted
+	} else if st.ctx.Err() != nil {
+		// 客户端已经取消的流不需要再发送结果
+		st.server.observeCall(st.serviceName, st.handler != nil, st.ctx.Err(), start)
+		return
+	}
+	st.server.observeCall(st.serviceName, st.handler != nil, err, start)
+
+	if err != nil {
+		st.write(FrameStreamEnd, st.header.appendError(nil, err))
+	} else {
+		st.write(FrameStreamEnd, []byte{0xfe})
+	}
+}
+
+// check 在调用拦截器之前检查权限、处理函数和编码方式
+func (st *ServerStream) check() error {
+	if err := st.server.authorize(st.client, st.serviceName); err != nil {
+		return err
+	}
+	if st.handler == nil {
+		return fmt.Errorf("brpc: can't find stream service %v", st.serviceName)
+	}
+
+	codec, err := st.header.codec()
+	if err != nil {
+		return err
+	}
+	st.codec = codec
+	return nil
+}
+
+// dispatch 在连接的读循环里调用，处理客户端发到这个流上的消息
+func (st *ServerStream) dispatch(f *Frame) {
+	// 流上的每条消息都要验证是不是同一个客户端发的，消息带着流的标识和序号，
+	// 不需要记录到防重放记录里
+	client, data, err := st.server.openMessage(&f.EPubKey, f.T, f.Data)
+	if err != nil || client.PublicKey != st.client.PublicKey {
+		return
+	}
+
+	data, err = st.key.checkPrefix(data, st.recvSeq)
+	if err != nil {
+		st.abort(ErrStreamSequence)
+		return
+	}
+	st.recvSeq++
+
+	switch f.Type {
+	case FrameStreamData:
+		if st.recvClosed {
+			return
+		}
+		// 不能阻塞读循环，处理不过来时结束这个流
+		select {
+		case st.recv <- data:
+		case <-st.ctx.Done():
+		default:
+			st.abort(ErrStreamOverflow)
+		}
+	case FrameStreamEnd:
+		if !st.recvClosed {
+			st.recvClosed = true
+			close(st.recv)
+		}
+	case FrameStreamCancel:
+		st.cancel()
+	}
+}
+
+// abort 以err结束流，处理函数的Recv和Send返回err，客户端也会收到err
+func (st *ServerStream) abort(err *Error) {
+	st.aborted.CompareAndSwap(nil, err)
+	st.cancel()
+}
+
+func (st *ServerStream) ctxErr() error {
+	if aborted := st.aborted.Load(); aborted != nil {
+		return aborted
+	}
+	return st.ctx.Err()
+}
@@ -0,0 +1,369 @@
+package brpc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func newStreamServer(t *testing.T) *testServer {
+	ts := newTestServer(t)
+	ts.HandleStream("log.Tail", func(st *brpc.ServerStream) error {
+		var n int
+		if err := st.Recv(&n); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := st.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	ts.HandleStream("up.Sum", func(st *brpc.ServerStream) error {
+		sum := 0
+		for {
+			var n int
+			err := st.Recv(&n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			sum += n
+		}
+		return st.Send(sum)
+	})
+	ts.HandleStream("bidi.Echo", func(st *brpc.ServerStream) error {
+		for {
+			var v string
+			err := st.Recv(&v)
+			if err == io.EOF {
+				return errors.New("bye")
+			}
+			if err != nil {
+				return err
+			}
+			if err := st.Send(v + "!"); err != nil {
+				return err
+			}
+		}
+	})
+	return ts
+}
+
+var streamModes = []struct {
+	name string
+	opts []func(*rw.Client)
+}{
+	{"conn", nil},
+	{"mux", []func(*rw.Client){rw.WithMux()}},
+}
+
+func TestServerStream(t *testing.T) {
+	ts := newStreamServer(t)
+	for _, mode := range streamModes {
+		t.Run(mode.name, func(t *testing.T) {
+			c := ts.client(t, mode.opts...)
+			st, err := c.NewStream(context.Background(), "log.Tail")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			st.Send(50)
+			st.CloseSend()
+
+			got := 0
+			for {
+				var v int
+				err := st.Recv(&v)
+				if err == io.EOF {
+					break
+				}
+				if err != nil || v != got {
+					t.Fatal(err, v)
+				}
+				got++
+			}
+			if got != 50 {
+				t.Fatal(got)
+			}
+		})
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	ts := newStreamServer(t)
+	for _, mode := range streamModes {
+		t.Run(mode.name, func(t *testing.T) {
+			c := ts.client(t, mode.opts...)
+			st, err := c.NewStream(context.Background(), "up.Sum")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			for i := 1; i <= 10; i++ {
+				if err := st.Send(i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var sum int
+			if err := st.CloseAndRecv(&sum); err != nil || sum != 55 {
+				t.Fatal(err, sum)
+			}
+		})
+	}
+}
+
+func TestBidiStream(t *testing.T) {
+	ts := newStreamServer(t)
+	for _, mode := range streamModes {
+		t.Run(mode.name, func(t *testing.T) {
+			c := ts.client(t, mode.opts...)
+			st, err := c.NewStream(context.Background(), "bidi.Echo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			for _, s := range []string{"a", "b"} {
+				st.Send(s)
+				var v string
+				if err := st.Recv(&v); err != nil || v != s+"!" {
+					t.Fatal(err, v)
+				}
+			}
+			st.CloseSend()
+			var v string
+			if err := st.Recv(&v); err == nil || err.Error() != "bye" {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStreamNotFound(t *testing.T) {
+	ts := newStreamServer(t)
+	c := ts.client(t, rw.WithMux())
+	st, err := c.NewStream(context.Background(), "no.Such")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.Recv(nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// 出错的流不影响连接上的其他调用
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	ts := newTestServer(t)
+	cancelled := make(chan struct{})
+	ts.HandleStream("slow.Wait", func(st *brpc.ServerStream) error {
+		<-st.Context().Done()
+		close(cancelled)
+		return nil
+	})
+
+	c := ts.client(t, rw.WithMux())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	st, err := c.NewStream(ctx, "slow.Wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Recv(nil); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server stream not cancelled")
+	}
+}
+
+func TestStreamOverflow(t *testing.T) {
+	ts := newTestServer(t)
+	ts.SetStreamRecvBuffer(2)
+	ts.HandleStream("stuck.Recv", func(st *brpc.ServerStream) error {
+		<-st.Context().Done()
+		return st.Send(0)
+	})
+
+	c := ts.client(t, rw.WithMux())
+	st, err := c.NewStream(context.Background(), "stuck.Recv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	for i := 0; i < 10; i++ {
+		st.Send(i)
+	}
+	for {
+		if err = st.Recv(nil); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, brpc.ErrStreamOverflow) {
+		t.Fatal(err)
+	}
+
+	// 读循环没有被阻塞，连接上的其他调用不受影响
+	var out Resp
+	if err := c.Call("service.Query", Req{Name: "admin"}, &out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// frameConn 是直接收发帧的连接，用来模拟不按规矩发送的客户端
+type frameConn struct {
+	t      *testing.T
+	conn   io.ReadWriteCloser
+	client *brpc.Client
+}
+
+func (ts *testServer) frameConn(t *testing.T) *frameConn {
+	conn, err := ts.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := brpc.WriteHandshake(conn, brpc.FrameVersion2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := brpc.ReadHandshake(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(brpc.Client)
+	c.SetClientPrivateKey(ts.clientKey)
+	c.SetServerPublicKey(ts.serverKey)
+	return &frameConn{t: t, conn: conn, client: c}
+}
+
+// rawStream 是直接收发帧的流，自己维护两个方向上的消息序号
+type rawStream struct {
+	fc      *frameConn
+	key     brpc.StreamKey
+	sendSeq uint64
+	recvSeq uint64
+}
+
+// openStream 发送打开流的帧
+func (fc *frameConn) openStream(id uint32, serviceName string) *rawStream {
+	f := &brpc.Frame{Type: brpc.FrameStreamOpen, ID: id}
+	var err error
+	f.Data, err = fc.client.WriteRequestMessage(nil, serviceName, nil, &f.EPubKey, &f.T)
+	if err != nil {
+		fc.t.Fatal(err)
+	}
+	fc.write(f)
+	return &rawStream{fc: fc, key: brpc.StreamKey{ID: id, EPubKey: f.EPubKey}}
+}
+
+// send 在流上发送一条消息，返回发送的帧
+func (rs *rawStream) send(typ byte, msg any) *brpc.Frame {
+	f := &brpc.Frame{Type: typ, ID: rs.key.ID}
+	var err error
+	f.Data, err = rs.fc.client.WriteStreamMessage(nil, &rs.key, rs.sendSeq, msg, &f.EPubKey, &f.T)
+	if err != nil {
+		rs.fc.t.Fatal(err)
+	}
+	rs.sendSeq++
+	rs.fc.write(f)
+	return f
+}
+
+// recv 解析服务端发到这个流上的帧
+func (rs *rawStream) recv(f *brpc.Frame, msg any) error {
+	if f.ID != rs.key.ID {
+		rs.fc.t.Fatal("frame of stream", f.ID)
+	}
+	return rs.fc.client.ReadStreamMessage(msg, &rs.key, &rs.recvSeq, f.Data, &f.EPubKey, f.T)
+}
+
+func (fc *frameConn) write(f *brpc.Frame) {
+	if err := brpc.WriteFrame(fc.conn, brpc.FrameVersion2, f); err != nil {
+		fc.t.Fatal(err)
+	}
+}
+
+func (fc *frameConn) read() *brpc.Frame {
+	f := new(brpc.Frame)
+	if err := brpc.ReadFrame(fc.conn, brpc.FrameVersion2, brpc.DefaultMaxMessageSize, f); err != nil {
+		fc.t.Fatal(err)
+	}
+	return f
+}
+
+func TestStreamIDInUse(t *testing.T) {
+	ts := newStreamServer(t)
+	fc := ts.frameConn(t)
+
+	// 第二次使用同一个ID打开流会被拒绝，不会替换掉正在使用的流
+	rs := fc.openStream(1, "up.Sum")
+	fc.openStream(1, "up.Sum")
+	f := fc.read()
+	if f.Type != brpc.FrameReject || f.ID != 1 || brpc.RejectionError(string(f.Data)) != brpc.ErrStreamIDInUse {
+		t.Fatal(f.Type, f.ID, string(f.Data))
+	}
+
+	rs.send(brpc.FrameStreamData, 5)
+	rs.send(brpc.FrameStreamEnd, nil)
+	f = fc.read()
+	var sum int
+	if err := rs.recv(f, &sum); err != nil || f.Type != brpc.FrameStreamData || sum != 5 {
+		t.Fatal(f.Type, err, sum)
+	}
+}
+
+func TestStreamReplay(t *testing.T) {
+	ts := newStreamServer(t)
+	fc := ts.frameConn(t)
+
+	// 重放流上的消息，服务端以ErrStreamSequence结束这个流
+	rs := fc.openStream(1, "up.Sum")
+	f := rs.send(brpc.FrameStreamData, 5)
+	fc.write(f)
+	end := fc.read()
+	if err := rs.recv(end, nil); end.Type != brpc.FrameStreamEnd || !errors.Is(err, brpc.ErrStreamSequence) {
+		t.Fatal(end.Type, err)
+	}
+
+	// 把消息移到另一个流上也一样
+	a := fc.openStream(2, "up.Sum")
+	b := fc.openStream(3, "up.Sum")
+	f = a.send(brpc.FrameStreamData, 5)
+	f.ID = b.key.ID
+	fc.write(f)
+	end = fc.read()
+	if err := b.recv(end, nil); end.Type != brpc.FrameStreamEnd || !errors.Is(err, brpc.ErrStreamSequence) {
+		t.Fatal(end.Type, err)
+	}
+
+	// a不受影响
+	a.send(brpc.FrameStreamEnd, nil)
+	f = fc.read()
+	replay := *f
+	replay.Data = bytes.Clone(f.Data)
+	var sum int
+	if err := a.recv(f, &sum); err != nil || sum != 5 {
+		t.Fatal(err, sum)
+	}
+
+	// 客户端同样不接受重复的消息
+	if err := a.recv(&replay, &sum); err != brpc.ErrStreamSequence {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+package brpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed 是Shutdown或者Close之后ServeListener返回的错误，
+// 之后收到的请求也会以这个原因被拒绝，客户端可以换一个服务端重试
+var ErrServerClosed = errors.New("brpc: server closed")
+
+const shutdownPollInterval = 50 * time.Millisecond
+
+// serverConn 是ServeListener接受的连接，记录连接上处理中的请求数，
+// Shutdown只关闭没有处理中请求的连接
+type serverConn struct {
+	net.Conn
+
+	lock   sync.Mutex
+	active int
+	closed bool
+}
+
+// begin 在开始处理请求前调用，连接已经被关闭时返回false
+func (c *serverConn) begin() bool {
+	if c == nil {
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false
+	}
+	c.active++
+	return true
+}
+
+// end 在请求的响应写完之后调用
+func (c *serverConn) end() {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.active--
+}
+
+// closeIfIdle 关闭没有处理中请求的连接，返回连接是否已经关闭
+func (c *serverConn) closeIfIdle() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.active > 0 {
+		return false
+	}
+	if !c.closed {
+		c.closed = true
+		c.Conn.Close()
+	}
+	return true
+}
+
+func (c *serverConn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.Conn.Close()
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+func (s *Server) trackListener(l *net.Listener, add bool) bool {
+	s.trackLock.Lock()
+	defer s.trackLock.Unlock()
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) trackConn(c *serverConn, add bool) bool {
+	s.trackLock.Lock()
+	defer s.trackLock.Unlock()
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+	return true
+}
+
+func (s *Server) closeListeners() error {
+	var err error
+	for l := range s.listeners {
+		if cerr := (*l).Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	clear(s.listeners)
+	return err
+}
+
+// Shutdown 停止ServeListener接受新的连接，拒绝新的请求，关闭空闲的连接，
+// 等处理中的请求都完成后关闭其他连接。ctx结束时还没有完成的连接会被强制关闭，并返回ctx的错误。
+// ServeHTTP的请求由http.Server管理，Shutdown之后只会被拒绝。
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.trackLock.Lock()
+	s.inShutdown.Store(true)
+	err := s.closeListeners()
+	s.trackLock.Unlock()
+
+	if s.health != nil {
+		s.health.Shutdown()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close 立即停止ServeListener并关闭所有连接，处理中的请求结果不会再发给客户端
+func (s *Server) Close() error {
+	s.trackLock.Lock()
+	defer s.trackLock.Unlock()
+
+	s.inShutdown.Store(true)
+	err := s.closeListeners()
+	for c := range s.conns {
+		c.Close()
+	}
+	clear(s.conns)
+	return err
+}
+
+// closeIdleConns 关闭空闲的连接，返回是否所有连接都已经关闭
+func (s *Server) closeIdleConns() bool {
+	s.trackLock.Lock()
+	defer s.trackLock.Unlock()
+
+	done := true
+	for c := range s.conns {
+		if c.closeIfIdle() {
+			delete(s.conns, c)
+		} else {
+			done = false
+		}
+	}
+	return done
+}
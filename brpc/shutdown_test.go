@@ -0,0 +1,80 @@
+package brpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abxuz/b-tools/v2/brpc"
+	"github.com/abxuz/b-tools/v2/brpc/rw"
+)
+
+func TestShutdown(t *testing.T) {
+	ts, started, release := blockingServer(t, brpc.Limits{})
+	c := ts.client(t, rw.WithMux())
+
+	inFlight := make(chan error, 1)
+	go func() {
+		var out int
+		inFlight <- c.Call("block.Wait", 1, &out)
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- ts.Shutdown(context.Background()) }()
+
+	// 停止过程中同一个连接上的新请求被拒绝，客户端可以换服务端重试
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var out Resp
+		err := c.Call("service.Query", Req{Name: "admin"}, &out)
+		if errors.Is(err, brpc.ErrServerClosed) {
+			break
+		}
+		if err != nil || time.Now().After(deadline) {
+			t.Fatal("during shutdown", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 处理中的请求完成之后Shutdown才返回
+	select {
+	case err := <-shutdown:
+		t.Fatal("shutdown returned early", err)
+	default:
+	}
+	close(release)
+	if err := <-inFlight; err != nil {
+		t.Fatal("in-flight call", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ts.served; err != brpc.ErrServerClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	ts, started, release := blockingServer(t, brpc.Limits{})
+	defer close(release)
+	c := ts.client(t, rw.WithMux())
+
+	inFlight := make(chan error, 1)
+	go func() {
+		var out int
+		inFlight <- c.Call("block.Wait", 1, &out)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ts.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	// 超时后连接被强制关闭
+	if err := <-inFlight; err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,132 @@
+package brpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// W3C Trace Context在metadata中使用的键
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceContext 是W3C traceparent中的信息
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+const TraceFlagSampled = 0x01
+
+// NewTraceContext 开始一个新的trace
+func NewTraceContext(sampled bool) TraceContext {
+	var tc TraceContext
+	rand.Read(tc.TraceID[:])
+	rand.Read(tc.SpanID[:])
+	if sampled {
+		tc.Flags = TraceFlagSampled
+	}
+	return tc
+}
+
+// ParseTraceparent 解析"00-{trace-id}-{parent-id}-{flags}"格式的traceparent，
+// 版本号更高时按规范只读取前面的部分
+func ParseTraceparent(s string) (TraceContext, error) {
+	var tc TraceContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, ErrInvalidTraceparent
+	}
+
+	version, err := hex.DecodeString(s[:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return tc, ErrInvalidTraceparent
+	}
+
+	if _, err := hex.Decode(tc.TraceID[:], []byte(s[3:35])); err != nil {
+		return tc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(s[36:52])); err != nil {
+		return tc, ErrInvalidTraceparent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return tc, ErrInvalidTraceparent
+	}
+	tc.Flags = flags[0]
+
+	if !tc.IsValid() {
+		return tc, ErrInvalidTraceparent
+	}
+	return tc, nil
+}
+
+// IsValid 全0的trace id和span id是无效的
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&TraceFlagSampled != 0
+}
+
+// NewSpan 返回同一个trace中的新span
+func (tc TraceContext) NewSpan() TraceContext {
+	rand.Read(tc.SpanID[:])
+	return tc
+}
+
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+type traceKey struct{}
+
+// ContextWithTrace 设置ctx上当前的span，之后经过TraceClientInterceptor的调用都以它为父span
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceFromContext 返回ctx上当前的span，没有设置时使用服务端收到的traceparent
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	if tc, ok := ctx.Value(traceKey{}).(TraceContext); ok {
+		return tc, true
+	}
+
+	traceparent := IncomingMetadata(ctx).Get(TraceparentKey)
+	if traceparent == "" {
+		return TraceContext{}, false
+	}
+	tc, err := ParseTraceparent(traceparent)
+	return tc, err == nil
+}
+
+// TraceInterceptor 是服务端拦截器，请求带有traceparent时为这次调用创建新的span，
+// 处理函数里发起的调用会以它为父span
+func TraceInterceptor(ctx context.Context, call *Call, next Handler) error {
+	if tc, ok := TraceFromContext(ctx); ok {
+		ctx = ContextWithTrace(ctx, tc.NewSpan())
+	}
+	return next(ctx, call)
+}
+
+// TraceClientInterceptor 是客户端拦截器，把ctx上当前的span作为traceparent发给服务端，
+// 在服务端处理请求时发起的调用还会转发收到的tracestate
+func TraceClientInterceptor(ctx context.Context, serviceName string, req any, resp any, invoker Invoker) error {
+	if OutgoingMetadata(ctx).Get(TraceparentKey) == "" {
+		if tc, ok := TraceFromContext(ctx); ok {
+			kv := []string{TraceparentKey, tc.String()}
+			if tracestate := IncomingMetadata(ctx).Get(TracestateKey); tracestate != "" {
+				kv = append(kv, TracestateKey, tracestate)
+			}
+			ctx = AppendToOutgoingContext(ctx, kv...)
+		}
+	}
+	return invoker(ctx, serviceName, req, resp)
+}